@@ -4,6 +4,9 @@ go 1.22.12
 
 replace github.com/MicahParks/jwkset => ../
 
-require github.com/MicahParks/jwkset v0.0.0-00010101000000-000000000000
+require (
+	github.com/MicahParks/jwkset v0.0.0-00010101000000-000000000000
+	github.com/golang-jwt/jwt/v5 v5.3.1
+)
 
 require golang.org/x/time v0.5.0 // indirect
@@ -0,0 +1,7 @@
+// Package jwkfuzz fuzzes the path a remote JWK Set takes into jwkset storage: the JSON decode,
+// NewJWKFromMarshal and KeyWrite, as done by the refresh function of NewStorageFromHTTP.
+//
+// Run the seeds with go test, or fuzz with:
+//
+//	go test -mod=mod -fuzz FuzzJWKSIngest ./jwk_fuzz
+package jwkfuzz
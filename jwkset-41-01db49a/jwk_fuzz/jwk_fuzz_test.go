@@ -0,0 +1,546 @@
+package jwkfuzz
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MicahParks/jwkset"
+)
+
+// knownClasses are the finding classes jwkset v0.5.x is known to produce. They are logged
+// instead of failing, so that fuzzing stops on new behaviour only. Panics and unclassified
+// errors are never in the default list: a panic in NewJWKFromMarshal crashes the refresh
+// goroutine of NewStorageFromHTTP. Unknown panics always fail
+const knownClasses = "ec-point,ec-private,private-dropped,rsa-exponent,rsa-exponent-truncated,rsa-modulus,rsa-small-modulus"
+
+// knownPanics gives panics whose cause is understood a class of their own, so that they can be
+// named in -jwk-fuzz-allow while investigating other findings
+var knownPanics = map[string]string{
+	// A P-384 point with crv P-256 is written into 32 byte buffers with big.Int.FillBytes
+	"math/big: buffer too small": "panic-buffer-too-small",
+}
+
+// panicFinding classifies a recovered panic
+func panicFinding(r any) finding {
+	message := fmt.Sprint(r)
+	for prefix, class := range knownPanics {
+		if strings.HasPrefix(message, prefix) {
+			return finding{class, message}
+		}
+	}
+	return finding{"panic", message}
+}
+
+var allowClasses = flag.String("jwk-fuzz-allow", knownClasses, "comma separated finding classes that are logged instead of failing, empty to fail on all")
+
+// ingested is a key that made it into the storage
+type ingested struct {
+	Index  int
+	Source jwkset.JWKMarshal
+	JWK    jwkset.JWK
+}
+
+// ingest processes a JWK Set document like the refresh function of NewStorageFromHTTP: the
+// first invalid key stops the refresh, the keys before it stay written
+func ingest(ctx context.Context, doc []byte, store jwkset.Storage) ([]ingested, error) {
+	var jwks jwkset.JWKSMarshal
+	err := json.NewDecoder(bytes.NewReader(doc)).Decode(&jwks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWK Set response: %w", err)
+	}
+	var accepted []ingested
+	for i, marshal := range jwks.Keys {
+		marshalOptions := jwkset.JWKMarshalOptions{
+			Private: true,
+		}
+		jwk, err := jwkset.NewJWKFromMarshal(marshal, marshalOptions, jwkset.JWKValidateOptions{})
+		if err != nil {
+			return accepted, fmt.Errorf("failed to create JWK from JWK Marshal: %w", err)
+		}
+		err = store.KeyWrite(ctx, jwk)
+		if err != nil {
+			return accepted, fmt.Errorf("failed to write JWK to memory storage: %w", err)
+		}
+		accepted = append(accepted, ingested{Index: i, Source: marshal, JWK: jwk})
+	}
+	return accepted, nil
+}
+
+// finding is one problem found in a document. Class groups findings of the same kind
+type finding struct {
+	Class  string
+	Detail string
+}
+
+func (f finding) String() string {
+	return f.Class + ": " + f.Detail
+}
+
+func rsaFindings(key *rsa.PublicKey, source jwkset.JWKMarshal) []finding {
+	var findings []finding
+	if key.N == nil || key.N.Sign() <= 0 || key.N.Bit(0) == 0 {
+		findings = append(findings, finding{"rsa-modulus", "modulus is not a positive odd number"})
+	} else if key.N.BitLen() < 1024 {
+		findings = append(findings, finding{"rsa-small-modulus", fmt.Sprintf("%d bit modulus", key.N.BitLen())})
+	}
+	if key.E < 3 || key.E%2 == 0 {
+		findings = append(findings, finding{"rsa-exponent", fmt.Sprintf("exponent %d is not an odd number >= 3", key.E)})
+	}
+	if e, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(source.E, "=")); err == nil {
+		if new(big.Int).SetBytes(e).Cmp(big.NewInt(int64(key.E))) != 0 {
+			findings = append(findings, finding{"rsa-exponent-truncated", fmt.Sprintf("e=%q became %d", source.E, key.E)})
+		}
+	}
+	return findings
+}
+
+// curveNames maps the Go curve names to the JWK crv values
+var curveNames = map[string]jwkset.CRV{
+	"P-256": jwkset.CrvP256,
+	"P-384": jwkset.CrvP384,
+	"P-521": jwkset.CrvP521,
+}
+
+func ecFindings(key *ecdsa.PublicKey, marshal jwkset.JWKMarshal) []finding {
+	var findings []finding
+	if crv := curveNames[key.Curve.Params().Name]; crv != marshal.CRV {
+		findings = append(findings, finding{"crv-mismatch", fmt.Sprintf("crv %q holds a %s key", marshal.CRV, key.Curve.Params().Name)})
+	}
+	if _, err := key.ECDH(); err != nil {
+		findings = append(findings, finding{"ec-point", err.Error()})
+	}
+	return findings
+}
+
+// keyFindings checks an accepted key independently of jwkset
+func keyFindings(accepted ingested) []finding {
+	marshal := accepted.JWK.Marshal()
+	kty := func(expected jwkset.KTY) []finding {
+		if marshal.KTY != expected {
+			return []finding{{"kty-mismatch", fmt.Sprintf("kty %q holds a %T", marshal.KTY, accepted.JWK.Key())}}
+		}
+		return nil
+	}
+
+	var findings []finding
+	switch key := accepted.JWK.Key().(type) {
+	case []byte:
+		findings = append(findings, kty(jwkset.KtyOct)...)
+		if len(key) == 0 {
+			findings = append(findings, finding{"empty-secret", "oct key without key material"})
+		}
+	case *rsa.PublicKey:
+		findings = append(findings, kty(jwkset.KtyRSA)...)
+		findings = append(findings, rsaFindings(key, accepted.Source)...)
+		if accepted.Source.D != "" {
+			findings = append(findings, finding{"private-dropped", "d is present but the key was stored as a public key"})
+		}
+	case *rsa.PrivateKey:
+		findings = append(findings, kty(jwkset.KtyRSA)...)
+		findings = append(findings, rsaFindings(&key.PublicKey, accepted.Source)...)
+		if err := key.Validate(); err != nil {
+			findings = append(findings, finding{"rsa-private", err.Error()})
+		}
+	case *ecdsa.PublicKey:
+		findings = append(findings, kty(jwkset.KtyEC)...)
+		findings = append(findings, ecFindings(key, marshal)...)
+		if accepted.Source.D != "" {
+			findings = append(findings, finding{"private-dropped", "d is present but the key was stored as a public key"})
+		}
+	case *ecdsa.PrivateKey:
+		findings = append(findings, kty(jwkset.KtyEC)...)
+		findings = append(findings, ecFindings(&key.PublicKey, marshal)...)
+		if private, err := key.ECDH(); err != nil {
+			findings = append(findings, finding{"ec-private", err.Error()})
+		} else if public, err := key.PublicKey.ECDH(); err == nil && !private.PublicKey().Equal(public) {
+			findings = append(findings, finding{"ec-private", "d does not match x and y"})
+		}
+	case ed25519.PublicKey:
+		findings = append(findings, kty(jwkset.KtyOKP)...)
+		if marshal.CRV != jwkset.CrvEd25519 || len(key) != ed25519.PublicKeySize {
+			findings = append(findings, finding{"okp-length", fmt.Sprintf("crv %q with a %d byte Ed25519 key", marshal.CRV, len(key))})
+		}
+	case ed25519.PrivateKey:
+		findings = append(findings, kty(jwkset.KtyOKP)...)
+		if len(key) != ed25519.PrivateKeySize {
+			findings = append(findings, finding{"okp-length", fmt.Sprintf("%d byte Ed25519 private key", len(key))})
+		} else if !ed25519.NewKeyFromSeed(key.Seed()).Public().(ed25519.PublicKey).Equal(key.Public()) {
+			findings = append(findings, finding{"ed25519-private", "d does not match x"})
+		}
+	case *ecdh.PublicKey, *ecdh.PrivateKey:
+		findings = append(findings, kty(jwkset.KtyOKP)...)
+		if marshal.CRV != jwkset.CrvX25519 {
+			findings = append(findings, finding{"crv-mismatch", fmt.Sprintf("crv %q holds an X25519 key", marshal.CRV)})
+		}
+	default:
+		findings = append(findings, finding{"unexpected-type", fmt.Sprintf("%T", key)})
+	}
+
+	if _, err := jwkset.NewJWKFromMarshal(marshal, jwkset.JWKMarshalOptions{Private: true}, jwkset.JWKValidateOptions{}); err != nil {
+		findings = append(findings, finding{"round-trip", fmt.Sprintf("the stored key does not parse again: %v", err)})
+	}
+	return findings
+}
+
+// classifiedError reports whether err belongs to one of the error classes a caller can act on:
+// a jwkset sentinel or a JSON or base64 decode error
+func classifiedError(err error) bool {
+	for _, sentinel := range []error{
+		jwkset.ErrKeyUnmarshalParameter,
+		jwkset.ErrJWKValidation,
+		jwkset.ErrUnsupportedKey,
+		jwkset.ErrOptions,
+		jwkset.ErrX509Mismatch,
+		jwkset.ErrPadding,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+	} {
+		if errors.Is(err, sentinel) {
+			return true
+		}
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var base64Err base64.CorruptInputError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.As(err, &base64Err)
+}
+
+// result is the outcome of one ingest, comparable across runs
+type result struct {
+	Accepted []jwkset.JWKMarshal
+	Err      string
+}
+
+func run(ctx context.Context, doc []byte) (res result, accepted []ingested, store jwkset.Storage, err error) {
+	store = jwkset.NewMemoryStorage()
+	accepted, err = ingest(ctx, doc, store)
+	for _, a := range accepted {
+		res.Accepted = append(res.Accepted, a.JWK.Marshal())
+	}
+	if err != nil {
+		res.Err = err.Error()
+	}
+	return res, accepted, store, err
+}
+
+// documentFindings runs every check on one document
+func documentFindings(doc []byte) (findings []finding) {
+	ctx := context.Background()
+	defer func() {
+		if r := recover(); r != nil {
+			findings = append(findings, panicFinding(r))
+		}
+	}()
+
+	first, accepted, store, err := run(ctx, doc)
+	if second, _, _, _ := run(ctx, doc); !reflect.DeepEqual(first, second) {
+		findings = append(findings, finding{"nondeterministic", fmt.Sprintf("%+v then %+v", first, second)})
+	}
+	if err != nil && !classifiedError(err) {
+		findings = append(findings, finding{"unclassified-error", err.Error()})
+	}
+
+	for _, a := range accepted {
+		for _, f := range keyFindings(a) {
+			f.Detail = fmt.Sprintf("key %d: %s", a.Index, f.Detail)
+			findings = append(findings, f)
+		}
+	}
+
+	// The last accepted key of each kid is the one KeyWrite must have kept
+	last := map[string]jwkset.JWKMarshal{}
+	for _, a := range accepted {
+		last[a.JWK.Marshal().KID] = a.JWK.Marshal()
+	}
+	for kid, expected := range last {
+		stored, err := store.KeyRead(ctx, kid)
+		if err != nil || !reflect.DeepEqual(stored.Marshal(), expected) {
+			findings = append(findings, finding{"storage-mismatch", fmt.Sprintf("kid %q reads back %+v, %v", kid, stored.Marshal(), err)})
+		}
+	}
+
+	// Every key that passed the document path must pass on its own, and the other way round
+	var raw struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if json.Unmarshal(doc, &raw) == nil && err == nil {
+		for i, key := range raw.Keys {
+			if _, err := jwkset.NewJWKFromRawJSON(key, jwkset.JWKMarshalOptions{Private: true}, jwkset.JWKValidateOptions{}); err != nil {
+				findings = append(findings, finding{"raw-path-mismatch", fmt.Sprintf("key %d accepted in the set, rejected alone: %v", i, err)})
+			}
+		}
+	}
+	return findings
+}
+
+// reportFindings fails on every finding whose class is not allowed and logs the others
+func reportFindings(t *testing.T, doc []byte, findings []finding) {
+	t.Helper()
+
+	allowed := map[string]bool{}
+	for _, class := range strings.Split(*allowClasses, ",") {
+		allowed[strings.TrimSpace(class)] = true
+	}
+	for _, f := range findings {
+		if allowed[f.Class] && f.Class != "panic" {
+			t.Logf("⚠️  known %s", f)
+			continue
+		}
+		t.Errorf("🔥 %s\n    document: %s", f, doc)
+	}
+}
+
+// seedDocument is a named JWK Set document used as a fuzz seed
+type seedDocument struct {
+	Name string
+	Doc  []byte
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func jwks(keys ...map[string]any) []byte {
+	doc, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+// with returns a copy of key with the members in set changed, nil values remove the member
+func with(key map[string]any, set map[string]any) map[string]any {
+	out := map[string]any{}
+	for k, v := range key {
+		out[k] = v
+	}
+	for k, v := range set {
+		if v == nil {
+			delete(out, k)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// seedKeysPath holds the private keys the seeds are built from. They are fixed so that a
+// finding reported for seed#N can be replayed on the next run
+const seedKeysPath = "testdata/seed_keys.json"
+
+// loadSeedKeys returns the JWKs in seedKeysPath by kid
+func loadSeedKeys(tb testing.TB) map[string]map[string]any {
+	tb.Helper()
+
+	data, err := os.ReadFile(seedKeysPath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var set struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		tb.Fatalf("%s: %v", seedKeysPath, err)
+	}
+	keys := map[string]map[string]any{}
+	for _, key := range set.Keys {
+		keys[key["kid"].(string)] = key
+	}
+	return keys
+}
+
+// decodeMember returns the base64url decoded member of a seed key
+func decodeMember(tb testing.TB, key map[string]any, member string) []byte {
+	tb.Helper()
+
+	data, err := base64.RawURLEncoding.DecodeString(key[member].(string))
+	if err != nil {
+		tb.Fatalf("%s member %q of %q: %v", seedKeysPath, member, key["kid"], err)
+	}
+	return data
+}
+
+// seedDocuments builds well-formed keys from the fixed seed keys and the malformed variants of them
+func seedDocuments(tb testing.TB) []seedDocument {
+	tb.Helper()
+
+	keys := loadSeedKeys(tb)
+	public := map[string]any{"d": nil}
+	rsaModulus := new(big.Int).SetBytes(decodeMember(tb, keys["rsa"], "n"))
+	edPublic := decodeMember(tb, keys["ed"], "x")
+
+	oct := map[string]any{"kty": "oct", "kid": "oct", "k": b64([]byte("0123456789abcdef0123456789abcdef"))}
+	rsaPrivate := keys["rsa"]
+	rsaPublic := with(rsaPrivate, map[string]any{"d": nil, "p": nil, "q": nil, "dp": nil, "dq": nil, "qi": nil})
+	ecP256 := with(keys["p256"], public)
+	ecP384 := with(keys["p384"], public)
+	okpPrivate := keys["ed"]
+	okp := with(okpPrivate, public)
+	x25519Public := with(keys["x25519"], public)
+
+	octJSON := string(jwks(oct))
+	octMember := octJSON[len(`{"keys":[`) : len(octJSON)-len(`]}`)]
+
+	return []seedDocument{
+		{"valid oct", jwks(oct)},
+		{"valid RSA public", jwks(rsaPublic)},
+		{"valid RSA private", jwks(rsaPrivate)},
+		{"valid EC P-256", jwks(ecP256)},
+		{"valid EC P-384", jwks(ecP384)},
+		{"valid Ed25519", jwks(okp)},
+		{"valid Ed25519 private", jwks(okpPrivate)},
+		{"valid X25519", jwks(x25519Public)},
+		{"valid mixed set", jwks(oct, rsaPublic, ecP256, okp)},
+
+		{"wrong kty: oct params as RSA", jwks(with(oct, map[string]any{"kty": "RSA"}))},
+		{"wrong kty: RSA params as EC", jwks(with(rsaPublic, map[string]any{"kty": "EC"}))},
+		{"wrong kty: EC params as OKP", jwks(with(ecP256, map[string]any{"kty": "OKP"}))},
+		{"wrong kty: OKP params as EC", jwks(with(okp, map[string]any{"kty": "EC"}))},
+		{"wrong kty: RSA params as oct", jwks(with(rsaPublic, map[string]any{"kty": "oct"}))},
+		{"wrong kty: lower case", jwks(with(rsaPublic, map[string]any{"kty": "rsa"}))},
+		{"wrong kty: empty", jwks(with(oct, map[string]any{"kty": ""}))},
+
+		{"missing k", jwks(with(oct, map[string]any{"k": nil}))},
+		{"missing n", jwks(with(rsaPublic, map[string]any{"n": nil}))},
+		{"missing e", jwks(with(rsaPublic, map[string]any{"e": nil}))},
+		{"missing x", jwks(with(ecP256, map[string]any{"x": nil}))},
+		{"missing y", jwks(with(ecP256, map[string]any{"y": nil}))},
+		{"missing crv", jwks(with(ecP256, map[string]any{"crv": nil}))},
+		{"missing OKP x", jwks(with(okp, map[string]any{"x": nil}))},
+		{"RSA private with wrong d", jwks(with(rsaPrivate, map[string]any{"d": "AQ"}))},
+		{"RSA private without p", jwks(with(rsaPrivate, map[string]any{"p": nil}))},
+		{"EC private with wrong d", jwks(with(ecP256, map[string]any{"d": b64(big.NewInt(7).FillBytes(make([]byte, 32)))}))},
+
+		{"invalid base64url: symbols", jwks(with(oct, map[string]any{"k": "!!!!"}))},
+		{"invalid base64url: std alphabet", jwks(with(rsaPublic, map[string]any{"n": base64.StdEncoding.EncodeToString(rsaModulus.Bytes())}))},
+		{"invalid base64url: inner padding", jwks(with(ecP256, map[string]any{"x": "QU=FB"}))},
+		{"invalid base64url: whitespace", jwks(with(okp, map[string]any{"x": " " + b64(edPublic)}))},
+		{"invalid base64url: trailing padding", jwks(with(oct, map[string]any{"k": oct["k"].(string) + "=="}))},
+
+		{"huge exponent: 2^64+1", jwks(with(rsaPublic, map[string]any{"e": b64(new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 64), big.NewInt(1)).Bytes())}))},
+		{"huge exponent: 1 KiB", jwks(with(rsaPublic, map[string]any{"e": b64(bytes.Repeat([]byte{0xff}, 1024))}))},
+		{"huge exponent: 2^63", jwks(with(rsaPublic, map[string]any{"e": b64(new(big.Int).Lsh(big.NewInt(1), 63).Bytes())}))},
+		{"exponent 0", jwks(with(rsaPublic, map[string]any{"e": "AA"}))},
+		{"exponent 1", jwks(with(rsaPublic, map[string]any{"e": "AQ"}))},
+		{"exponent 2", jwks(with(rsaPublic, map[string]any{"e": "Ag"}))},
+		{"tiny modulus", jwks(with(rsaPublic, map[string]any{"n": b64([]byte{0x00, 0xc5})}))},
+		{"even modulus", jwks(with(rsaPublic, map[string]any{"n": b64(new(big.Int).Lsh(rsaModulus, 1).Bytes())}))},
+
+		{"mismatched crv: P-256 point as P-384", jwks(with(ecP256, map[string]any{"crv": "P-384"}))},
+		{"mismatched crv: P-384 point as P-256", jwks(with(ecP384, map[string]any{"crv": "P-256"}))},
+		{"mismatched crv: Ed25519 as X25519", jwks(with(okp, map[string]any{"crv": "X25519"}))},
+		{"mismatched crv: EC as Ed25519", jwks(with(ecP256, map[string]any{"crv": "Ed25519"}))},
+		{"unknown crv", jwks(with(ecP256, map[string]any{"crv": "secp256k1"}))},
+		{"point not on curve", jwks(with(ecP256, map[string]any{"x": b64(big.NewInt(1).FillBytes(make([]byte, 32))), "y": b64(big.NewInt(1).FillBytes(make([]byte, 32)))}))},
+		{"short Ed25519 key", jwks(with(okp, map[string]any{"x": b64(edPublic[:31])}))},
+
+		{"duplicate member: kty", []byte(`{"keys":[` + strings.Replace(octMember, `"kty":"oct"`, `"kty":"oct","kty":"RSA"`, 1) + `]}`)},
+		{"duplicate member: k", []byte(`{"keys":[` + strings.Replace(octMember, `"k":`, `"k":"QUFBQQ","k":`, 1) + `]}`)},
+		{"duplicate member: case folded kty", []byte(`{"keys":[` + strings.Replace(octMember, `"kty":"oct"`, `"kty":"oct","KTY":"RSA"`, 1) + `]}`)},
+		{"duplicate member: keys", []byte(`{"keys":[` + octMember + `],"keys":[]}`)},
+		{"duplicate kid", jwks(oct, with(oct, map[string]any{"k": b64([]byte("another secret"))}))},
+
+		{"extra fields: unknown string", jwks(with(oct, map[string]any{"foo": "bar"}))},
+		{"extra fields: nested object", jwks(with(rsaPublic, map[string]any{"ext": map[string]any{"n": "AQ"}}))},
+		{"extra fields: top level", []byte(`{"keys":[` + octMember + `],"next":"https://example.invalid"}`)},
+
+		{"wrong type: e as number", []byte(`{"keys":[{"kty":"RSA","n":"` + rsaPublic["n"].(string) + `","e":65537}]}`)},
+		{"wrong type: keys as object", []byte(`{"keys":{"kty":"oct"}}`)},
+		{"invalid key after a valid one", jwks(oct, with(rsaPublic, map[string]any{"n": nil}))},
+		{"empty document", []byte(``)},
+		{"trailing garbage", []byte(octJSON + `}`)},
+	}
+}
+
+// FuzzJWKSIngest mutates JWK Set documents and checks every stored key, the errors and the
+// consistency between the set, single key and storage paths
+func FuzzJWKSIngest(f *testing.F) {
+	for _, seed := range seedDocuments(f) {
+		f.Add(seed.Doc)
+	}
+	f.Fuzz(func(t *testing.T, doc []byte) {
+		reportFindings(t, doc, documentFindings(doc))
+	})
+}
+
+// TestSeedFindings lists the findings of every seed by name
+func TestSeedFindings(t *testing.T) {
+	for _, seed := range seedDocuments(t) {
+		t.Run(seed.Name, func(t *testing.T) {
+			reportFindings(t, seed.Doc, documentFindings(seed.Doc))
+		})
+	}
+}
+
+// TestIngestMatchesHTTPStorage checks that ingest stores the same keys as NewStorageFromHTTP
+func TestIngestMatchesHTTPStorage(t *testing.T) {
+	for _, seed := range seedDocuments(t) {
+		t.Run(seed.Name, func(t *testing.T) {
+			doc := seed.Doc
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write(doc)
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Without RefreshInterval the first refresh runs on this goroutine, so a panic can be
+			// recovered here. With a RefreshInterval the same panic crashes the process
+			httpStore := jwkset.NewMemoryStorage()
+			var httpErr error
+			var httpPanic any
+			func() {
+				defer func() {
+					httpPanic = recover()
+				}()
+				_, httpErr = jwkset.NewStorageFromHTTP(u, jwkset.HTTPClientStorageOptions{
+					Client:  srv.Client(),
+					Storage: httpStore,
+				})
+			}()
+			if httpPanic != nil {
+				t.Fatalf("🔥 NewStorageFromHTTP panicked, a background refresh would crash the process: %v", httpPanic)
+			}
+			_, _, ingestStore, ingestErr := run(context.Background(), doc)
+			if (httpErr == nil) != (ingestErr == nil) {
+				t.Fatalf("❌ NewStorageFromHTTP error %v, ingest error %v", httpErr, ingestErr)
+			}
+
+			stored := func(store jwkset.Storage) []jwkset.JWKMarshal {
+				keys, err := store.KeyReadAll(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				var marshals []jwkset.JWKMarshal
+				for _, jwk := range keys {
+					marshals = append(marshals, jwk.Marshal())
+				}
+				return marshals
+			}
+			if actual, expected := stored(httpStore), stored(ingestStore); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("❌ NewStorageFromHTTP stored %+v, ingest stored %+v", actual, expected)
+			}
+		})
+	}
+}
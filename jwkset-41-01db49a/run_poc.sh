@@ -32,6 +32,26 @@ echo ""
 
 go run -mod=mod main.go
 
+echo ""
+echo ""
+echo "----------------------------------------------------------------------"
+echo "POC 3: kid Header Abuse Against NewStorageFromHTTP"
+echo "----------------------------------------------------------------------"
+echo "Hostile kid values verified through a keyfunc backed by jwkset storage"
+echo ""
+
+go run -mod=mod ./kid_abuse
+
+echo ""
+echo ""
+echo "----------------------------------------------------------------------"
+echo "POC 4: Malformed JWK Seeds Through the Storage Refresh Path"
+echo "----------------------------------------------------------------------"
+echo "Run with -fuzz FuzzJWKSIngest to mutate the seeds further"
+echo ""
+
+go test -mod=mod -v -run TestSeedFindings ./jwk_fuzz
+
 echo ""
 echo ""
 echo "======================================================================"
@@ -45,5 +65,7 @@ echo ""
 echo "🔍 Key files:"
 echo "   - vulnerable_version.go (comparison POC)"
 echo "   - main.go (library test POC)"
+echo "   - kid_abuse/main.go (kid header abuse POC)"
+echo "   - jwk_fuzz/ (malformed JWK fuzzer)"
 echo "   - ../storage.go:265-289 (fix location)"
 echo ""
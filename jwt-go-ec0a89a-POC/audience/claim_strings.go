@@ -0,0 +1,94 @@
+package audience
+
+import (
+	"encoding/json"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+)
+
+// ClaimStrings is the value of the aud claim, which is either a single string
+// or an array of strings. A nil ClaimStrings means the claim is absent, an
+// empty non-nil one means it is present but names no audience.
+type ClaimStrings []string
+
+// UnmarshalJSON accepts a string, an array of strings or null. null leaves the
+// claim absent, as that is what encoding/json produces for a nil []string.
+func (s *ClaimStrings) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := claimStringsFrom(value)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// MarshalJSON keeps an empty ClaimStrings as [] instead of turning it into
+// null, so that tokens with an empty audience can still be produced.
+func (s ClaimStrings) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal([]string(s))
+}
+
+// Empty reports whether the claim is present but names no audience, that is
+// [] or a list of empty strings.
+func (s ClaimStrings) Empty() bool {
+	if s == nil {
+		return false
+	}
+	for _, aud := range s {
+		if aud != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// FromMapClaims extracts the aud claim. It returns nil if aud is missing or
+// null and ErrInvalidAudience if aud is not a string or an array of strings.
+func FromMapClaims(claims jwt.MapClaims) (ClaimStrings, error) {
+	return claimStringsFrom(claims["aud"])
+}
+
+func claimStringsFrom(value interface{}) (ClaimStrings, error) {
+	switch aud := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if aud == "" {
+			return ClaimStrings{}, nil
+		}
+		return ClaimStrings{aud}, nil
+	case []string:
+		return copyClaimStrings(aud), nil
+	case ClaimStrings:
+		return copyClaimStrings(aud), nil
+	case []interface{}:
+		list := make(ClaimStrings, 0, len(aud))
+		for i, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: element %d is %T", ErrInvalidAudience, i, a)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("%w: aud is %T", ErrInvalidAudience, value)
+	}
+}
+
+// copyClaimStrings keeps a nil slice nil, since it is marshalled as null
+func copyClaimStrings(aud []string) ClaimStrings {
+	if aud == nil {
+		return nil
+	}
+	return append(ClaimStrings{}, aud...)
+}
@@ -0,0 +1,117 @@
+package audience
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+)
+
+var claimStringsTestData = []struct {
+	name    string
+	payload string
+	aud     ClaimStrings
+	empty   bool
+	err     error
+}{
+	{"absent", `{}`, nil, false, nil},
+	{"null", `{"aud":null}`, nil, false, nil},
+	{"empty string", `{"aud":""}`, ClaimStrings{}, true, nil},
+	{"string", `{"aud":"protected-api"}`, ClaimStrings{"protected-api"}, false, nil},
+	{"empty array", `{"aud":[]}`, ClaimStrings{}, true, nil},
+	{"array of empty string", `{"aud":[""]}`, ClaimStrings{""}, true, nil},
+	{"array", `{"aud":["a","b"]}`, ClaimStrings{"a", "b"}, false, nil},
+	{"array of number", `{"aud":[123]}`, nil, false, ErrInvalidAudience},
+	{"mixed array", `{"aud":["a",null]}`, nil, false, ErrInvalidAudience},
+	{"number", `{"aud":123}`, nil, false, ErrInvalidAudience},
+	{"object", `{"aud":{}}`, nil, false, ErrInvalidAudience},
+}
+
+func TestClaimStringsUnmarshal(t *testing.T) {
+	for _, data := range claimStringsTestData {
+		var claims struct {
+			Audience ClaimStrings `json:"aud"`
+		}
+		err := json.Unmarshal([]byte(data.payload), &claims)
+
+		if !errors.Is(err, data.err) {
+			t.Errorf("[%v] Expected error %v, got %v", data.name, data.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(claims.Audience, data.aud) {
+			t.Errorf("[%v] Expected %#v, got %#v", data.name, data.aud, claims.Audience)
+		}
+		if claims.Audience.Empty() != data.empty {
+			t.Errorf("[%v] Expected Empty() to be %v", data.name, data.empty)
+		}
+	}
+}
+
+func TestFromMapClaims(t *testing.T) {
+	for _, data := range claimStringsTestData {
+		claims := jwt.MapClaims{}
+		if err := json.Unmarshal([]byte(data.payload), &claims); err != nil {
+			t.Fatalf("[%v] %v", data.name, err)
+		}
+
+		aud, err := FromMapClaims(claims)
+		if !errors.Is(err, data.err) {
+			t.Errorf("[%v] Expected error %v, got %v", data.name, data.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(aud, data.aud) {
+			t.Errorf("[%v] Expected %#v, got %#v", data.name, data.aud, aud)
+		}
+	}
+}
+
+func TestFromMapClaimsGoValues(t *testing.T) {
+	var testData = []struct {
+		name  string
+		value interface{}
+		aud   ClaimStrings
+	}{
+		{"nil string slice", []string(nil), nil},
+		{"empty string slice", []string{}, ClaimStrings{}},
+		{"string slice", []string{"a"}, ClaimStrings{"a"}},
+		{"claim strings", ClaimStrings{"a"}, ClaimStrings{"a"}},
+		{"empty interface slice", []interface{}{}, ClaimStrings{}},
+	}
+
+	for _, data := range testData {
+		aud, err := FromMapClaims(jwt.MapClaims{"aud": data.value})
+		if err != nil {
+			t.Errorf("[%v] Unexpected error %v", data.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(aud, data.aud) {
+			t.Errorf("[%v] Expected %#v, got %#v", data.name, data.aud, aud)
+		}
+	}
+}
+
+func TestClaimStringsMarshal(t *testing.T) {
+	var testData = []struct {
+		aud      ClaimStrings
+		expected string
+	}{
+		{nil, `null`},
+		{ClaimStrings{}, `[]`},
+		{ClaimStrings{"a", "b"}, `["a","b"]`},
+	}
+
+	for _, data := range testData {
+		out, err := json.Marshal(data.aud)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != data.expected {
+			t.Errorf("Expected %#v to marshal to %v, got %v", data.aud, data.expected, string(out))
+		}
+	}
+}
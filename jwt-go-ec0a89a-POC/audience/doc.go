@@ -0,0 +1,12 @@
+// Package audience verifies the aud claim against an explicit policy.
+//
+// Unlike MapClaims.VerifyAudience, a missing aud claim and an empty one are
+// not the same thing here. A token without aud may come from an old client,
+// but a token with `"aud": []` or `"aud": ""` was built that way on purpose.
+//
+//	policy := audience.AnyOf("protected-api")
+//	policy.AllowAbsent = true // accept old clients, still reject aud=[]
+//	if err := policy.VerifyMapClaims(claims); err != nil {
+//	    return err
+//	}
+package audience
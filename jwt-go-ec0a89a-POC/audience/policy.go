@@ -0,0 +1,121 @@
+package audience
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+)
+
+// Error constants
+var (
+	ErrAudienceAbsent   = errors.New("aud claim is absent")
+	ErrAudienceEmpty    = errors.New("aud claim is empty")
+	ErrInvalidAudience  = errors.New("aud claim is not a string or an array of strings")
+	ErrAudienceMismatch = errors.New("aud claim does not match the expected audience")
+	ErrNoExpected       = errors.New("policy has no expected audience")
+)
+
+// Match selects how the expected audiences are compared with the claim
+type Match int
+
+const (
+	// AnyOfMatch accepts the claim if it names at least one expected audience
+	AnyOfMatch Match = iota
+	// AllOfMatch accepts the claim only if it names every expected audience
+	AllOfMatch
+)
+
+func (m Match) String() string {
+	switch m {
+	case AnyOfMatch:
+		return "any-of"
+	case AllOfMatch:
+		return "all-of"
+	}
+	return fmt.Sprintf("Match(%d)", int(m))
+}
+
+// Policy describes which aud claims are accepted.
+// The zero values of AllowAbsent and AllowEmpty are the strict choice.
+type Policy struct {
+	Expected []string
+	Match    Match
+	// AllowAbsent accepts tokens without an aud claim, e.g. from old clients
+	AllowAbsent bool
+	// AllowEmpty treats aud=[] and aud="" like an absent claim, other empty
+	// shapes such as [""] then fail to match. Only set this to reproduce the
+	// behaviour of VerifyAudience with required=false
+	AllowEmpty bool
+}
+
+// AnyOf returns a strict policy that accepts a claim naming any of expected
+func AnyOf(expected ...string) Policy {
+	return Policy{Expected: expected, Match: AnyOfMatch}
+}
+
+// AllOf returns a strict policy that accepts a claim naming all of expected
+func AllOf(expected ...string) Policy {
+	return Policy{Expected: expected, Match: AllOfMatch}
+}
+
+// Verify checks aud against the policy. A nil aud is an absent claim.
+func (p Policy) Verify(aud ClaimStrings) error {
+	if len(p.Expected) == 0 {
+		return ErrNoExpected
+	}
+
+	if aud.Empty() {
+		if !p.AllowEmpty {
+			return ErrAudienceEmpty
+		}
+		if len(aud) == 0 {
+			aud = nil
+		}
+	}
+	if aud == nil {
+		if p.AllowAbsent {
+			return nil
+		}
+		return ErrAudienceAbsent
+	}
+
+	switch p.Match {
+	case AnyOfMatch:
+		for _, expected := range p.Expected {
+			if contains(aud, expected) {
+				return nil
+			}
+		}
+		return ErrAudienceMismatch
+	case AllOfMatch:
+		for _, expected := range p.Expected {
+			if !contains(aud, expected) {
+				return ErrAudienceMismatch
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown match %v", p.Match)
+}
+
+// VerifyMapClaims checks the aud claim of claims against the policy
+func (p Policy) VerifyMapClaims(claims jwt.MapClaims) error {
+	aud, err := FromMapClaims(claims)
+	if err != nil {
+		return err
+	}
+	return p.Verify(aud)
+}
+
+// contains compares in constant time, like the checks in the jwt package
+func contains(aud ClaimStrings, expected string) bool {
+	found := false
+	for _, a := range aud {
+		if subtle.ConstantTimeCompare([]byte(a), []byte(expected)) != 0 {
+			found = true
+		}
+	}
+	return found
+}
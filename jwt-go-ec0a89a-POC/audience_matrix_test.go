@@ -0,0 +1,180 @@
+package jwt
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var audienceMatrixOut = flag.String("audience-matrix", "", "write the VerifyAudience verdict matrix to this file")
+
+// matrixAudience is the audience the protected API expects in the matrix tests
+const matrixAudience = "protected-api"
+
+// audienceShape is one representation of the aud claim. Shapes given as JSON are decoded the same
+// way the parser decodes a token payload, Go shapes cover values only a Go issuer can produce
+type audienceShape struct {
+	Name string
+	// JSON is the raw value of the aud member, the member is omitted if both JSON and Value are unset
+	JSON string
+	// Value is used instead of JSON for shapes that have no JSON representation
+	Value interface{}
+	// Matches is whether the shape legitimately names the expected audience
+	Matches bool
+}
+
+// Claims decodes the shape into a fresh MapClaims
+func (shape audienceShape) Claims() (MapClaims, error) {
+	claims := MapClaims{}
+	switch {
+	case shape.Value != nil:
+		claims["aud"] = shape.Value
+	case shape.JSON != "":
+		if err := json.Unmarshal([]byte(`{"aud":`+shape.JSON+`}`), &claims); err != nil {
+			return nil, fmt.Errorf("shape %q: %v", shape.Name, err)
+		}
+	}
+	return claims, nil
+}
+
+// audienceShapes generates every aud shape that is relevant for VerifyAudience
+func audienceShapes(expected string) []audienceShape {
+	quoted := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+	repeated := func(element string, count int) string {
+		return "[" + strings.TrimSuffix(strings.Repeat(element+",", count), ",") + "]"
+	}
+	caseVariant := strings.ToUpper(expected[:1]) + expected[1:]
+
+	return []audienceShape{
+		{Name: "absent"},
+		{Name: "null", JSON: `null`},
+		{Name: "empty string", JSON: `""`},
+		{Name: "expected string", JSON: quoted(expected), Matches: true},
+		{Name: "wrong string", JSON: `"wrong"`},
+		{Name: "case variant string", JSON: quoted(caseVariant)},
+		{Name: "upper case string", JSON: quoted(strings.ToUpper(expected))},
+		{Name: "padded string", JSON: quoted(" " + expected + " ")},
+		{Name: "number", JSON: `123`},
+		{Name: "boolean", JSON: `true`},
+		{Name: "object", JSON: `{"aud":` + quoted(expected) + `}`},
+		{Name: "empty array", JSON: `[]`},
+		{Name: "array of empty string", JSON: `[""]`},
+		{Name: "array of expected", JSON: `[` + quoted(expected) + `]`, Matches: true},
+		{Name: "array of wrong", JSON: `["wrong"]`},
+		{Name: "array of case variant", JSON: `[` + quoted(caseVariant) + `]`},
+		{Name: "array of number", JSON: `[123]`},
+		{Name: "array of null", JSON: `[null]`},
+		{Name: "array of boolean", JSON: `[true]`},
+		{Name: "array of object", JSON: `[{}]`},
+		{Name: "nested empty array", JSON: `[[]]`},
+		{Name: "nested array of expected", JSON: `[[` + quoted(expected) + `]]`},
+		{Name: "mixed number and expected", JSON: `[123,` + quoted(expected) + `]`, Matches: true},
+		{Name: "mixed null and expected", JSON: `[null,` + quoted(expected) + `]`, Matches: true},
+		{Name: "mixed number and wrong", JSON: `[123,"wrong"]`},
+		{Name: "mixed nested and wrong", JSON: `[[` + quoted(expected) + `],"wrong"]`},
+		{Name: "long array of wrong", JSON: repeated(`"wrong"`, 10000)},
+		{Name: "long array of numbers", JSON: repeated(`1`, 10000)},
+		{Name: "long array ending in expected", JSON: strings.TrimSuffix(repeated(`"wrong"`, 10000), "]") + `,` + quoted(expected) + `]`, Matches: true},
+		{Name: "go empty string slice", Value: []string{}},
+		{Name: "go string slice of expected", Value: []string{expected}, Matches: true},
+		{Name: "go string slice of wrong", Value: []string{"wrong"}},
+		{Name: "go empty interface slice", Value: []interface{}{}},
+	}
+}
+
+// audienceVerdict is the outcome of one VerifyAudience call
+type audienceVerdict string
+
+const (
+	verdictAccept audienceVerdict = "accept"
+	verdictReject audienceVerdict = "reject"
+	verdictPanic  audienceVerdict = "panic"
+)
+
+func verifyAudienceVerdict(claims MapClaims, expected string, required bool) (verdict audienceVerdict) {
+	defer func() {
+		if r := recover(); r != nil {
+			verdict = verdictPanic
+		}
+	}()
+
+	if claims.VerifyAudience(expected, required) {
+		return verdictAccept
+	}
+	return verdictReject
+}
+
+// audienceMatrixRow holds the verdicts of one shape for required=true and required=false
+type audienceMatrixRow struct {
+	Shape    audienceShape
+	Required audienceVerdict
+	Optional audienceVerdict
+}
+
+func buildAudienceMatrix(expected string) ([]audienceMatrixRow, error) {
+	var rows []audienceMatrixRow
+	for _, shape := range audienceShapes(expected) {
+		claims, err := shape.Claims()
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, audienceMatrixRow{
+			Shape:    shape,
+			Required: verifyAudienceVerdict(claims, expected, true),
+			Optional: verifyAudienceVerdict(claims, expected, false),
+		})
+	}
+	return rows, nil
+}
+
+// formatAudienceMatrix renders the matrix as aligned plain text, one shape per line, so that the
+// output of two jwt-go versions can be compared with diff
+func formatAudienceMatrix(expected string, rows []audienceMatrixRow) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# MapClaims.VerifyAudience(%q, required)\n", expected)
+	fmt.Fprintf(&b, "%-32s %-15s %s\n", "shape", "required=true", "required=false")
+	for _, row := range rows {
+		fmt.Fprintf(&b, "%-32s %-15s %s\n", row.Shape.Name, row.Required, row.Optional)
+	}
+	return b.String()
+}
+
+// TestAudienceShapeMatrix runs VerifyAudience on every aud shape and reports the verdict matrix.
+// Use -audience-matrix=FILE to write the matrix for diffing between jwt-go versions
+func TestAudienceShapeMatrix(t *testing.T) {
+	rows, err := buildAudienceMatrix(matrixAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matrix := formatAudienceMatrix(matrixAudience, rows)
+	t.Logf("Verdict matrix:\n%s", matrix)
+
+	if *audienceMatrixOut != "" {
+		if err := ioutil.WriteFile(*audienceMatrixOut, []byte(matrix), 0644); err != nil {
+			t.Fatalf("failed to write matrix: %v", err)
+		}
+		t.Logf("Matrix written to %s", *audienceMatrixOut)
+	}
+
+	for _, row := range rows {
+		if row.Required == verdictPanic || row.Optional == verdictPanic {
+			t.Errorf("❌ VerifyAudience panicked for shape %q", row.Shape.Name)
+		}
+		if row.Shape.Matches && row.Required != verdictAccept {
+			t.Errorf("❌ Shape %q names the expected audience but is rejected with required=true", row.Shape.Name)
+		}
+		if !row.Shape.Matches && row.Required == verdictAccept {
+			t.Errorf("🔥 Shape %q is accepted with required=true without naming the expected audience", row.Shape.Name)
+		}
+		if !row.Shape.Matches && row.Optional == verdictAccept && row.Shape.Name != "absent" {
+			t.Logf("🔥 Shape %q bypasses the audience check with required=false", row.Shape.Name)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package jwt
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var claimsParityOut = flag.String("claims-parity", "", "write the MapClaims vs StandardClaims disagreements to this file")
+
+// parityIssuer is the issuer the parity checks expect, the audience is matrixAudience
+const parityIssuer = "https://issuer.example"
+
+// parityNow is the fixed clock of the parity harness
+var parityNow = time.Unix(1700000000, 0)
+
+var parityKey = []byte("parity-secret")
+
+// parityValue is one JSON value of a claim, an empty JSON omits the claim
+type parityValue struct {
+	Name string
+	JSON string
+}
+
+// parityClaimValues lists the values generated for every claim the harness varies. All other
+// claims keep the valid baseline value while one claim is varied
+func parityClaimValues(now time.Time) map[string][]parityValue {
+	unix := func(d time.Duration) string {
+		return fmt.Sprint(now.Add(d).Unix())
+	}
+
+	return map[string][]parityValue{
+		"aud": {
+			{"absent", ""},
+			{"expected", `"` + matrixAudience + `"`},
+			{"wrong", `"other-api"`},
+			{"empty string", `""`},
+			{"null", `null`},
+			{"empty array", `[]`},
+			{"array of expected", `["` + matrixAudience + `"]`},
+			{"array of empty string", `[""]`},
+			{"array of number", `[123]`},
+			{"array with expected and wrong", `["other-api","` + matrixAudience + `"]`},
+		},
+		"iss": {
+			{"absent", ""},
+			{"expected", `"` + parityIssuer + `"`},
+			{"wrong", `"https://evil.example"`},
+			{"empty string", `""`},
+			{"null", `null`},
+			{"number", `123`},
+			{"array of expected", `["` + parityIssuer + `"]`},
+		},
+		"exp": {
+			{"absent", ""},
+			{"future", unix(time.Hour)},
+			{"past", unix(-time.Hour)},
+			{"future fraction", unix(time.Hour) + ".5"},
+			{"past fraction", unix(-time.Hour) + ".5"},
+			{"string future", `"` + unix(time.Hour) + `"`},
+			{"string past", `"` + unix(-time.Hour) + `"`},
+			{"null", `null`},
+			{"zero", `0`},
+			{"negative", `-1`},
+			{"huge", `9999999999`},
+			{"exponent", `1e300`},
+			{"array", `[` + unix(-time.Hour) + `]`},
+		},
+		"nbf": {
+			{"absent", ""},
+			{"past", unix(-time.Minute)},
+			{"future", unix(time.Hour)},
+			{"future fraction", unix(time.Hour) + ".5"},
+			{"string future", `"` + unix(time.Hour) + `"`},
+			{"null", `null`},
+			{"zero", `0`},
+			{"negative", `-1`},
+			{"array", `[` + unix(time.Hour) + `]`},
+		},
+	}
+}
+
+// parityBaseline is a payload that passes every check on both paths
+func parityBaseline(now time.Time) map[string]string {
+	return map[string]string{
+		"sub": `"user@example.com"`,
+		"aud": `"` + matrixAudience + `"`,
+		"iss": `"` + parityIssuer + `"`,
+		"exp": fmt.Sprint(now.Add(time.Hour).Unix()),
+		"nbf": fmt.Sprint(now.Add(-time.Minute).Unix()),
+	}
+}
+
+// parityPayload renders the baseline with claim replaced by value, in a stable member order
+func parityPayload(baseline map[string]string, claim string, value parityValue) string {
+	members := map[string]string{}
+	for k, v := range baseline {
+		members[k] = v
+	}
+	if value.JSON == "" {
+		delete(members, claim)
+	} else {
+		members[claim] = value.JSON
+	}
+
+	keys := make([]string, 0, len(members))
+	for k := range members {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, `"`+k+`":`+members[k])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// signRawPayload signs payload as is, so that values NewWithClaims cannot produce can be tested
+func signRawPayload(payload string, key []byte) (string, error) {
+	header := EncodeSegment([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	signingString := header + "." + EncodeSegment([]byte(payload))
+	signature, err := SigningMethodHS256.Sign(signingString, key)
+	if err != nil {
+		return "", err
+	}
+	return signingString + "." + signature, nil
+}
+
+// parityCheck is one verification run on both claims types
+type parityCheck struct {
+	Name     string
+	Map      func(MapClaims, int64) bool
+	Standard func(*StandardClaims, int64) bool
+}
+
+var parityChecks = []parityCheck{
+	{
+		"VerifyAudience(required=false)",
+		func(c MapClaims, _ int64) bool { return c.VerifyAudience(matrixAudience, false) },
+		func(c *StandardClaims, _ int64) bool { return c.VerifyAudience(matrixAudience, false) },
+	},
+	{
+		"VerifyAudience(required=true)",
+		func(c MapClaims, _ int64) bool { return c.VerifyAudience(matrixAudience, true) },
+		func(c *StandardClaims, _ int64) bool { return c.VerifyAudience(matrixAudience, true) },
+	},
+	{
+		"VerifyIssuer(required=false)",
+		func(c MapClaims, _ int64) bool { return c.VerifyIssuer(parityIssuer, false) },
+		func(c *StandardClaims, _ int64) bool { return c.VerifyIssuer(parityIssuer, false) },
+	},
+	{
+		"VerifyIssuer(required=true)",
+		func(c MapClaims, _ int64) bool { return c.VerifyIssuer(parityIssuer, true) },
+		func(c *StandardClaims, _ int64) bool { return c.VerifyIssuer(parityIssuer, true) },
+	},
+	{
+		"VerifyExpiresAt(required=false)",
+		func(c MapClaims, now int64) bool { return c.VerifyExpiresAt(now, false) },
+		func(c *StandardClaims, now int64) bool { return c.VerifyExpiresAt(now, false) },
+	},
+	{
+		"VerifyExpiresAt(required=true)",
+		func(c MapClaims, now int64) bool { return c.VerifyExpiresAt(now, true) },
+		func(c *StandardClaims, now int64) bool { return c.VerifyExpiresAt(now, true) },
+	},
+	{
+		"VerifyNotBefore(required=false)",
+		func(c MapClaims, now int64) bool { return c.VerifyNotBefore(now, false) },
+		func(c *StandardClaims, now int64) bool { return c.VerifyNotBefore(now, false) },
+	},
+	{
+		"VerifyNotBefore(required=true)",
+		func(c MapClaims, now int64) bool { return c.VerifyNotBefore(now, true) },
+		func(c *StandardClaims, now int64) bool { return c.VerifyNotBefore(now, true) },
+	},
+}
+
+// parityVerdict is the outcome of one path: accept, reject or a parse error
+type parityVerdict struct {
+	Accepted bool
+	// ParseError is set if the token could not be decoded into the claims type at all
+	ParseError string
+}
+
+func (v parityVerdict) String() string {
+	switch {
+	case v.ParseError != "":
+		return "parse error (" + v.ParseError + ")"
+	case v.Accepted:
+		return "accept"
+	}
+	return "reject"
+}
+
+// parsed is the verdict of the Parse pseudo check, Accepted holds the result of Valid
+func (v parityVerdict) parsed() string {
+	switch {
+	case v.ParseError != "":
+		return v.String()
+	case v.Accepted:
+		return "decoded and valid"
+	}
+	return "decoded but invalid"
+}
+
+// parityDisagreement is a check where MapClaims and StandardClaims come to different verdicts
+type parityDisagreement struct {
+	Claim    string
+	Value    string
+	Check    string
+	Map      parityVerdict
+	Standard parityVerdict
+}
+
+func (d parityDisagreement) String() string {
+	if d.Check == "Parse" {
+		return fmt.Sprintf("%s=%s %s: MapClaims %s, StandardClaims %s", d.Claim, d.Value, d.Check, d.Map.parsed(), d.Standard.parsed())
+	}
+	return fmt.Sprintf("%s=%s %s: MapClaims %v, StandardClaims %v", d.Claim, d.Value, d.Check, d.Map, d.Standard)
+}
+
+// mapAccepts reports whether MapClaims accepts what StandardClaims rejects. For Parse that is a
+// token MapClaims decodes as valid and StandardClaims cannot decode at all
+func (d parityDisagreement) mapAccepts() bool {
+	if d.Check == "Parse" {
+		return d.Map.ParseError == "" && d.Map.Accepted
+	}
+	return d.Map.Accepted
+}
+
+// isMalformed tells decoding failures apart from claim validation errors, which Parse also
+// returns but which leave the claims populated
+func isMalformed(err error) bool {
+	ve, ok := err.(*ValidationError)
+	return ok && ve.Errors&ValidationErrorMalformed != 0
+}
+
+// runParity parses tokenString into both claims types and runs every check on each path. The
+// Valid pseudo check compares the claims validation done by Parse itself
+func runParity(tokenString string, now time.Time) (map[string][2]parityVerdict, error) {
+	keyFunc := func(*Token) (interface{}, error) { return parityKey, nil }
+
+	mapToken, mapErr := Parse(tokenString, keyFunc)
+	standard := &StandardClaims{}
+	_, standardErr := ParseWithClaims(tokenString, standard, keyFunc)
+
+	verdicts := map[string][2]parityVerdict{}
+	var mapBase, standardBase parityVerdict
+	if isMalformed(mapErr) {
+		mapBase.ParseError = mapErr.Error()
+	}
+	if isMalformed(standardErr) {
+		standardBase.ParseError = standardErr.Error()
+	}
+
+	valid := [2]parityVerdict{mapBase, standardBase}
+	valid[0].Accepted = mapErr == nil
+	valid[1].Accepted = standardErr == nil
+	verdicts["Valid"] = valid
+
+	for _, check := range parityChecks {
+		pair := [2]parityVerdict{mapBase, standardBase}
+		if mapBase.ParseError == "" {
+			claims, ok := mapToken.Claims.(MapClaims)
+			if !ok {
+				return nil, fmt.Errorf("Parse returned %T", mapToken.Claims)
+			}
+			pair[0].Accepted = check.Map(claims, now.Unix())
+		}
+		if standardBase.ParseError == "" {
+			pair[1].Accepted = check.Standard(standard, now.Unix())
+		}
+		verdicts[check.Name] = pair
+	}
+	return verdicts, nil
+}
+
+// collectParityDisagreements generates one token per claim value and returns every check where
+// the two paths do not both accept or both reject
+func collectParityDisagreements(now time.Time) ([]parityDisagreement, error) {
+	baseline := parityBaseline(now)
+	values := parityClaimValues(now)
+
+	claims := make([]string, 0, len(values))
+	for claim := range values {
+		claims = append(claims, claim)
+	}
+	sort.Strings(claims)
+
+	checkNames := []string{"Valid"}
+	for _, check := range parityChecks {
+		checkNames = append(checkNames, check.Name)
+	}
+
+	var disagreements []parityDisagreement
+	for _, claim := range claims {
+		for _, value := range values[claim] {
+			tokenString, err := signRawPayload(parityPayload(baseline, claim, value), parityKey)
+			if err != nil {
+				return nil, err
+			}
+			verdicts, err := runParity(tokenString, now)
+			if err != nil {
+				return nil, err
+			}
+
+			// A token only one path can decode is reported once instead of once per check
+			parse := verdicts["Valid"]
+			if (parse[0].ParseError == "") != (parse[1].ParseError == "") {
+				disagreements = append(disagreements, parityDisagreement{claim, value.Name, "Parse", parse[0], parse[1]})
+				continue
+			}
+
+			for _, name := range checkNames {
+				pair := verdicts[name]
+				if pair[0].Accepted != pair[1].Accepted {
+					disagreements = append(disagreements, parityDisagreement{claim, value.Name, name, pair[0], pair[1]})
+				}
+			}
+		}
+	}
+	return disagreements, nil
+}
+
+// TestClaimsParityBaseline checks that the harness itself agrees on a well formed token
+func TestClaimsParityBaseline(t *testing.T) {
+	defer func(f func() time.Time) { TimeFunc = f }(TimeFunc)
+	TimeFunc = func() time.Time { return parityNow }
+
+	tokenString, err := signRawPayload(parityPayload(parityBaseline(parityNow), "sub", parityValue{"kept", `"user@example.com"`}), parityKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verdicts, err := runParity(tokenString, parityNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pair := range verdicts {
+		if !pair[0].Accepted || !pair[1].Accepted {
+			t.Errorf("❌ Baseline token rejected by %s: MapClaims %v, StandardClaims %v", name, pair[0], pair[1])
+		}
+	}
+}
+
+// TestClaimsParity reports every claim value where MapClaims and StandardClaims disagree.
+// Use -claims-parity=FILE to write the report for diffing between jwt-go versions
+func TestClaimsParity(t *testing.T) {
+	defer func(f func() time.Time) { TimeFunc = f }(TimeFunc)
+	TimeFunc = func() time.Time { return parityNow }
+
+	disagreements, err := collectParityDisagreements(parityNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# MapClaims vs StandardClaims, aud=%q iss=%q now=%d\n", matrixAudience, parityIssuer, parityNow.Unix())
+	for _, d := range disagreements {
+		fmt.Fprintln(&b, d)
+	}
+	report := b.String()
+
+	if *claimsParityOut != "" {
+		if err := ioutil.WriteFile(*claimsParityOut, []byte(report), 0644); err != nil {
+			t.Fatalf("failed to write report: %v", err)
+		}
+		t.Logf("Report written to %s", *claimsParityOut)
+	}
+
+	if len(disagreements) == 0 {
+		t.Logf("✅ MapClaims and StandardClaims agree on every generated token")
+		return
+	}
+	t.Logf("Found %d disagreements:\n%s", len(disagreements), report)
+	for _, d := range disagreements {
+		if d.mapAccepts() {
+			t.Logf("🔥 MapClaims accepts what StandardClaims rejects: %v", d)
+		}
+	}
+}
+
+// TestParityPayloadIsValidJSON guards the generator against typos in the value table
+func TestParityPayloadIsValidJSON(t *testing.T) {
+	baseline := parityBaseline(parityNow)
+	for claim, values := range parityClaimValues(parityNow) {
+		for _, value := range values {
+			payload := parityPayload(baseline, claim, value)
+			if !json.Valid([]byte(payload)) {
+				t.Errorf("❌ %s=%s produces invalid JSON: %s", claim, value.Name, payload)
+			}
+		}
+	}
+}
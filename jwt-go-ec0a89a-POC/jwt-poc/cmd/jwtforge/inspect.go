@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+)
+
+// farFuture is how far ahead exp may be before it is flagged
+const farFuture = 10 * 365 * 24 * time.Hour
+
+// clockSkew is the tolerance for iat and nbf in the future
+const clockSkew = time.Minute
+
+// decodedToken is a token split into its parts without verifying anything
+type decodedToken struct {
+	Header        map[string]interface{}
+	Claims        map[string]interface{}
+	SigningString string
+	Signature     string
+}
+
+// decodeToken decodes header and claims with json.Number, so numbers are shown as written
+func decodeToken(tokenString string) (decodedToken, error) {
+	parts := strings.Split(strings.TrimSpace(tokenString), ".")
+	if len(parts) != 3 {
+		return decodedToken{}, fmt.Errorf("token has %d segments, expected 3", len(parts))
+	}
+
+	tok := decodedToken{
+		SigningString: parts[0] + "." + parts[1],
+		Signature:     parts[2],
+	}
+	for i, target := range []*map[string]interface{}{&tok.Header, &tok.Claims} {
+		data, err := jwt.DecodeSegment(parts[i])
+		if err != nil {
+			return decodedToken{}, fmt.Errorf("segment %d is not base64url: %v", i, err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(target); err != nil {
+			return decodedToken{}, fmt.Errorf("segment %d is not a JSON object: %v", i, err)
+		}
+	}
+	return tok, nil
+}
+
+// finding is a suspicious value in a token
+type finding struct {
+	Severity string
+	Field    string
+	Message  string
+}
+
+func (f finding) String() string {
+	return fmt.Sprintf("[%s] %s: %s", f.Severity, f.Field, f.Message)
+}
+
+// inspectToken flags header and claim values that are typical for forged or PoC tokens
+func inspectToken(tok decodedToken, now time.Time) []finding {
+	var findings []finding
+	add := func(severity, field, format string, args ...interface{}) {
+		findings = append(findings, finding{severity, field, fmt.Sprintf(format, args...)})
+	}
+
+	// Header
+	alg, _ := tok.Header["alg"].(string)
+	switch {
+	case alg == "":
+		add("high", "alg", "missing or not a string")
+	case strings.EqualFold(alg, "none"):
+		add("high", "alg", "%q disables signature verification", alg)
+	case jwt.GetSigningMethod(alg) == nil:
+		add("medium", "alg", "%q is not a registered signing method", alg)
+	}
+	for _, name := range []string{"jku", "x5u"} {
+		if value, present := tok.Header[name]; present {
+			add("high", name, "header points to a remote key: %v", value)
+		}
+	}
+	if _, present := tok.Header["jwk"]; present {
+		add("high", "jwk", "header embeds its own verification key")
+	}
+	if kid, present := tok.Header["kid"]; present {
+		if s, ok := kid.(string); !ok {
+			add("medium", "kid", "is %T, not a string", kid)
+		} else if strings.ContainsAny(s, "/\\'\";|` \x00") || strings.Contains(s, "..") {
+			add("high", "kid", "%q contains path or injection characters", s)
+		}
+	}
+	if tok.Signature == "" {
+		add("high", "signature", "is empty")
+	}
+
+	// Claims
+	if aud, present := tok.Claims["aud"]; present {
+		if message := suspiciousAudience(aud); message != "" {
+			add("high", "aud", "%s", message)
+		}
+	}
+
+	exp, present := tok.Claims["exp"]
+	switch {
+	case !present:
+		add("medium", "exp", "missing, the token never expires")
+	default:
+		t, ok := numericDate(exp)
+		switch {
+		case !ok:
+			add("high", "exp", "%v is not a number and is ignored by VerifyExpiresAt", exp)
+		case t.After(now.Add(farFuture)):
+			add("medium", "exp", "%v is %s, more than 10 years ahead", exp, t.UTC().Format(time.RFC3339))
+		case !t.After(now):
+			add("info", "exp", "%v is %s, the token is expired", exp, t.UTC().Format(time.RFC3339))
+		}
+	}
+	for _, name := range []string{"iat", "nbf"} {
+		value, present := tok.Claims[name]
+		if !present {
+			continue
+		}
+		t, ok := numericDate(value)
+		switch {
+		case !ok:
+			add("high", name, "%v is not a number and is ignored", value)
+		case t.After(now.Add(clockSkew)):
+			add("medium", name, "%v is %s, in the future", value, t.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if sub, present := tok.Claims["sub"]; present {
+		if s, ok := sub.(string); !ok || s == "" {
+			add("medium", "sub", "%#v is not a non-empty string", sub)
+		}
+	}
+	for _, name := range []string{"role", "roles", "scope", "admin", "is_admin", "groups"} {
+		if value, present := tok.Claims[name]; present && grantsAdmin(value) {
+			add("info", name, "grants admin privileges: %v", value)
+		}
+	}
+
+	return findings
+}
+
+// suspiciousAudience explains why an aud value is unusual, or returns "" if it is a plain
+// string or a non-empty array of strings
+func suspiciousAudience(aud interface{}) string {
+	switch v := aud.(type) {
+	case nil:
+		return "null, treated like an absent claim"
+	case string:
+		if v == "" {
+			return `empty string, passes VerifyAudience(..., false)`
+		}
+		return ""
+	case []interface{}:
+		if len(v) == 0 {
+			return "empty array, passes VerifyAudience(..., false)"
+		}
+		empty := true
+		for _, a := range v {
+			s, ok := a.(string)
+			if !ok {
+				return fmt.Sprintf("array contains %T, which VerifyAudience silently drops", a)
+			}
+			if s != "" {
+				empty = false
+			}
+		}
+		if empty {
+			return "array of empty strings"
+		}
+		return ""
+	}
+	return fmt.Sprintf("%T is neither a string nor an array, passes VerifyAudience(..., false)", aud)
+}
+
+func numericDate(value interface{}) (time.Time, bool) {
+	var seconds float64
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		seconds = f
+	case float64:
+		seconds = v
+	default:
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+func grantsAdmin(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.Contains(strings.ToLower(v), "admin")
+	case []interface{}:
+		for _, element := range v {
+			if grantsAdmin(element) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifySignature checks the signature with the verification key of pair
+func verifySignature(tok decodedToken, pair keyPair) error {
+	alg, _ := tok.Header["alg"].(string)
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return fmt.Errorf("unknown signing method %q", alg)
+	}
+	return method.Verify(tok.SigningString, tok.Signature, pair.Public)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+)
+
+// keyPair holds the signing key and the matching verification key. Private is nil
+// if only a public key was loaded, for HMAC both are the shared secret
+type keyPair struct {
+	Private interface{}
+	Public  interface{}
+}
+
+// loadKeyPair parses key data for method: a raw secret for HMAC, a PEM private or
+// public key otherwise
+func loadKeyPair(method jwt.SigningMethod, data []byte) (keyPair, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(data) == 0 {
+			return keyPair{}, errors.New("HMAC secret is empty")
+		}
+		return keyPair{Private: data, Public: data}, nil
+
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if private, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+			return keyPair{Private: private, Public: &private.PublicKey}, nil
+		}
+		public, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return keyPair{}, fmt.Errorf("not an RSA private or public key: %v", err)
+		}
+		return keyPair{Public: public}, nil
+
+	case *jwt.SigningMethodECDSA:
+		if private, err := jwt.ParseECPrivateKeyFromPEM(data); err == nil {
+			return keyPair{Private: private, Public: &private.PublicKey}, nil
+		}
+		public, err := jwt.ParseECPublicKeyFromPEM(data)
+		if err != nil {
+			return keyPair{}, fmt.Errorf("not an EC private or public key: %v", err)
+		}
+		return keyPair{Public: public}, nil
+
+	case *SigningMethodEd25519:
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return keyPair{}, errors.New("Ed25519 key must be PEM encoded")
+		}
+		if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			private, ok := parsed.(ed25519.PrivateKey)
+			if !ok {
+				return keyPair{}, fmt.Errorf("expected an Ed25519 private key, got %T", parsed)
+			}
+			return keyPair{Private: private, Public: private.Public()}, nil
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return keyPair{}, fmt.Errorf("not an Ed25519 private or public key: %v", err)
+		}
+		public, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return keyPair{}, fmt.Errorf("expected an Ed25519 public key, got %T", parsed)
+		}
+		return keyPair{Public: public}, nil
+	}
+
+	return keyPair{}, fmt.Errorf("unsupported signing method %v", method.Alg())
+}
+
+// generateKeyPair creates a fresh key for method. HMAC secrets are as long as the hash output
+func generateKeyPair(method jwt.SigningMethod) (keyPair, error) {
+	switch m := method.(type) {
+	case *jwt.SigningMethodHMAC:
+		secret := make([]byte, m.Hash.Size())
+		if _, err := rand.Read(secret); err != nil {
+			return keyPair{}, err
+		}
+		return keyPair{Private: secret, Public: secret}, nil
+
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return keyPair{}, err
+		}
+		return keyPair{Private: private, Public: &private.PublicKey}, nil
+
+	case *jwt.SigningMethodECDSA:
+		var curve elliptic.Curve
+		switch m.CurveBits {
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return keyPair{}, fmt.Errorf("unsupported curve size %d", m.CurveBits)
+		}
+		private, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return keyPair{}, err
+		}
+		return keyPair{Private: private, Public: &private.PublicKey}, nil
+
+	case *SigningMethodEd25519:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return keyPair{}, err
+		}
+		return keyPair{Private: private, Public: public}, nil
+	}
+
+	return keyPair{}, fmt.Errorf("unsupported signing method %v", method.Alg())
+}
+
+// encodeKeyPair returns the key files for pair in the formats loadKeyPair reads back.
+// public is nil for HMAC, where the secret is the only key
+func encodeKeyPair(pair keyPair) (private []byte, public []byte, err error) {
+	if secret, ok := pair.Private.([]byte); ok {
+		return secret, nil, nil
+	}
+
+	var block *pem.Block
+	switch key := pair.Private.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	default:
+		return nil, nil, fmt.Errorf("cannot encode private key of type %T", pair.Private)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pair.Public)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(block), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
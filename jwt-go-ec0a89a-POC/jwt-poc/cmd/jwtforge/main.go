@@ -0,0 +1,319 @@
+// An offline tool to forge and inspect PoC tokens.
+//
+// Example usage:
+// The following recreates the token payload from POC_SUMMARY.txt with a generated secret,
+// then inspects it.
+//
+//	jwtforge sign -alg HS256 -claim sub=attacker@evil.com -claim 'aud=[]' -claim role=admin \
+//	    -claim exp=9999999999 -key-out /tmp/poc.key | jwtforge inspect -key /tmp/poc.key -
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+)
+
+// argList collects repeated key=value flags
+type argList map[string]string
+
+func (l argList) String() string {
+	data, _ := json.Marshal(l)
+	return string(data)
+}
+
+func (l argList) Set(arg string) error {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid argument %q, must use format key=value", arg)
+	}
+	l[parts[0]] = parts[1]
+	return nil
+}
+
+// values decodes every value as JSON, falling back to the plain string, so that
+// aud=[] is an empty array and sub=alice is a string
+func (l argList) values() map[string]interface{} {
+	values := make(map[string]interface{}, len(l))
+	for k, v := range l {
+		dec := json.NewDecoder(strings.NewReader(v))
+		dec.UseNumber()
+		var value interface{}
+		if err := dec.Decode(&value); err == nil && !dec.More() {
+			values[k] = value
+		} else {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, "Usage: jwtforge sign|inspect [flags]\n")
+		return errors.New("no command given")
+	}
+
+	switch args[0] {
+	case "sign":
+		return signCommand(args[1:], stdin, stdout, stderr)
+	case "inspect":
+		return inspectCommand(args[1:], stdin, stdout, stderr)
+	}
+	return fmt.Errorf("unknown command %q, expected sign or inspect", args[0])
+}
+
+// Helper func: read input from a file, stdin for "-" or an empty object for "+"
+func loadData(p string, stdin io.Reader) ([]byte, error) {
+	switch p {
+	case "":
+		return nil, errors.New("no path specified")
+	case "-":
+		return ioutil.ReadAll(stdin)
+	case "+":
+		return []byte("{}"), nil
+	}
+	return ioutil.ReadFile(p)
+}
+
+func signCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	alg := flags.String("alg", "HS256", "signing algorithm: HS256, HS384, HS512, RS256, ES256, EdDSA, ...")
+	keyPath := flags.String("key", "", "path to the secret or PEM private key, a key is generated if empty")
+	secret := flags.String("secret", "", "HMAC secret given inline instead of -key")
+	secretB64 := flags.String("secret-b64", "", "HMAC secret as base64url, the form a generated secret is printed in")
+	keyOut := flags.String("key-out", "", "write a generated key to this path, the public key goes to PATH.pub")
+	claimsPath := flags.String("claims", "+", "path to a claims object, '-' to read from stdin, or '+' to use only -claim args")
+	claims := make(argList)
+	header := make(argList)
+	flags.Var(claims, "claim", "add a claim as key=value, the value is parsed as JSON if possible. may be used more than once")
+	flags.Var(header, "header", "add a header param as key=value. may be used more than once")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	method := jwt.GetSigningMethod(*alg)
+	if method == nil {
+		return fmt.Errorf("couldn't find signing method %v", *alg)
+	}
+
+	data, err := loadData(*claimsPath, stdin)
+	if err != nil {
+		return fmt.Errorf("couldn't read claims: %v", err)
+	}
+	tokenClaims := jwt.MapClaims{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&tokenClaims); err != nil {
+		return fmt.Errorf("couldn't parse claims JSON: %v", err)
+	}
+	for k, v := range claims.values() {
+		tokenClaims[k] = v
+	}
+
+	var pair keyPair
+	switch {
+	case *secret != "" || *secretB64 != "":
+		var secretData []byte
+		if secretData, err = inlineSecret(*secret, *secretB64); err == nil {
+			pair, err = loadKeyPair(method, secretData)
+		}
+	case *keyPath != "":
+		var keyData []byte
+		if keyData, err = ioutil.ReadFile(*keyPath); err == nil {
+			pair, err = loadKeyPair(method, keyData)
+		}
+	default:
+		if pair, err = generateKeyPair(method); err == nil {
+			err = writeGeneratedKey(pair, *keyOut, stderr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't load key: %v", err)
+	}
+	if pair.Private == nil {
+		return errors.New("signing needs a private key, got a public key")
+	}
+
+	token := jwt.NewWithClaims(method, tokenClaims)
+	for k, v := range header.values() {
+		token.Header[k] = v
+	}
+
+	out, err := token.SignedString(pair.Private)
+	if err != nil {
+		return fmt.Errorf("error signing token: %v", err)
+	}
+	fmt.Fprintln(stdout, out)
+	return nil
+}
+
+func writeGeneratedKey(pair keyPair, path string, stderr io.Writer) error {
+	private, public, err := encodeKeyPair(pair)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		if public == nil {
+			fmt.Fprintf(stderr, "Generated secret (base64url, pass it as -secret-b64): %s\n", jwt.EncodeSegment(private))
+		} else {
+			fmt.Fprintf(stderr, "Generated public key:\n%s", public)
+		}
+		return nil
+	}
+
+	if err := ioutil.WriteFile(path, private, 0600); err != nil {
+		return err
+	}
+	if public != nil {
+		if err := ioutil.WriteFile(path+".pub", public, 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(stderr, "Generated key written to %s\n", path)
+	return nil
+}
+
+func inspectCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	keyPath := flags.String("key", "", "path to the secret or PEM key to verify the signature with")
+	secret := flags.String("secret", "", "HMAC secret given inline instead of -key")
+	secretB64 := flags.String("secret-b64", "", "HMAC secret as base64url, the form a generated secret is printed in")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected one token, '@path' to read it from a file or '-' for stdin")
+	}
+
+	tokenString, err := tokenArg(flags.Arg(0), stdin)
+	if err != nil {
+		return fmt.Errorf("couldn't read token: %v", err)
+	}
+
+	tok, err := decodeToken(tokenString)
+	if err != nil {
+		return fmt.Errorf("malformed token: %v", err)
+	}
+
+	fmt.Fprintln(stdout, "Header:")
+	if err := printJSON(stdout, tok.Header); err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "Claims:")
+	if err := printJSON(stdout, tok.Claims); err != nil {
+		return err
+	}
+
+	if *keyPath != "" || *secret != "" || *secretB64 != "" {
+		fmt.Fprintf(stdout, "Signature: %s\n", signatureStatus(tok, *keyPath, *secret, *secretB64))
+	}
+
+	findings := inspectToken(tok, time.Now())
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank(findings[i].Severity) < severityRank(findings[j].Severity)
+	})
+	if len(findings) == 0 {
+		fmt.Fprintln(stdout, "✅ Nothing suspicious found")
+		return nil
+	}
+	fmt.Fprintln(stdout, "Findings:")
+	for _, f := range findings {
+		fmt.Fprintf(stdout, "  ⚠️  %v\n", f)
+	}
+	return nil
+}
+
+// tokenArg returns the token given on the command line, read from stdin for "-" or from the
+// file named after an "@". Anything else is the token itself, even if a file of that name exists
+func tokenArg(arg string, stdin io.Reader) (string, error) {
+	switch {
+	case arg == "-":
+		data, err := loadData(arg, stdin)
+		return string(data), err
+	case strings.HasPrefix(arg, "@"):
+		data, err := loadData(arg[1:], stdin)
+		return string(data), err
+	}
+	return arg, nil
+}
+
+// inlineSecret returns the HMAC secret of -secret or -secret-b64, only one of them may be set
+func inlineSecret(secret, secretB64 string) ([]byte, error) {
+	switch {
+	case secret != "" && secretB64 != "":
+		return nil, errors.New("use either -secret or -secret-b64")
+	case secretB64 != "":
+		data, err := jwt.DecodeSegment(secretB64)
+		if err != nil {
+			return nil, fmt.Errorf("-secret-b64 is not base64url: %v", err)
+		}
+		return data, nil
+	}
+	return []byte(secret), nil
+}
+
+func signatureStatus(tok decodedToken, keyPath, secret, secretB64 string) string {
+	alg, _ := tok.Header["alg"].(string)
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return fmt.Sprintf("not checked, unknown alg %q", alg)
+	}
+
+	keyData, err := inlineSecret(secret, secretB64)
+	if err != nil {
+		return fmt.Sprintf("not checked, %v", err)
+	}
+	if keyPath != "" {
+		if keyData, err = ioutil.ReadFile(keyPath); err != nil {
+			return fmt.Sprintf("not checked, %v", err)
+		}
+	}
+	pair, err := loadKeyPair(method, keyData)
+	if err != nil {
+		return fmt.Sprintf("not checked, %v", err)
+	}
+	if err := verifySignature(tok, pair); err != nil {
+		return fmt.Sprintf("❌ invalid (%v)", err)
+	}
+	return "✅ valid"
+}
+
+func severityRank(severity string) int {
+	switch severity {
+	case "high":
+		return 0
+	case "medium":
+		return 1
+	}
+	return 2
+}
+
+func printJSON(w io.Writer, j interface{}) error {
+	out, err := json.MarshalIndent(j, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
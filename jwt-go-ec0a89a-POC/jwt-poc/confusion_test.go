@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+)
+
+// confusionKey is one entry of the key ring a kid based keyfunc looks tokens up in.
+// Material is the key as it is stored on disk: the secret for HMAC, a PEM public key for RSA
+type confusionKey struct {
+	Alg      string
+	Material []byte
+	Parsed   interface{}
+}
+
+// confusionRing holds the keys the protected API trusts. The RSA public key is published,
+// so an attacker knows its PEM encoding
+type confusionRing struct {
+	keys    map[string]confusionKey
+	private *rsa.PrivateKey
+	pemKey  []byte
+}
+
+func newConfusionRing(secret []byte) (*confusionRing, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	return &confusionRing{
+		keys: map[string]confusionKey{
+			"hs-1": {Alg: "HS256", Material: secret, Parsed: secret},
+			"rs-1": {Alg: "RS256", Material: pemKey, Parsed: &private.PublicKey},
+		},
+		private: private,
+		pemKey:  pemKey,
+	}, nil
+}
+
+// keyfuncPattern is a way of writing the keyfunc passed to jwt.Parse
+type keyfuncPattern struct {
+	Name        string
+	Description string
+	KeyFunc     jwt.Keyfunc
+}
+
+func (r *confusionRing) lookup(token *jwt.Token) (confusionKey, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, found := r.keys[kid]
+	if !found {
+		return confusionKey{}, fmt.Errorf("unknown kid %q", kid)
+	}
+	return key, nil
+}
+
+// patterns returns the keyfunc of the demo server and the kid based patterns it is compared to
+func (r *confusionRing) patterns(srv *pocServer) []keyfuncPattern {
+	return []keyfuncPattern{
+		{
+			Name:        "demo",
+			Description: "pocServer.keyFunc: HMAC only, one shared secret, kid ignored",
+			KeyFunc:     srv.keyFunc,
+		},
+		{
+			Name:        "kid-typed",
+			Description: "kid lookup returning parsed keys, no alg check",
+			KeyFunc: func(token *jwt.Token) (interface{}, error) {
+				key, err := r.lookup(token)
+				return key.Parsed, err
+			},
+		},
+		{
+			Name:        "kid-bytes",
+			Description: "kid lookup returning stored bytes, parsed only when the token says RSA",
+			KeyFunc: func(token *jwt.Token) (interface{}, error) {
+				key, err := r.lookup(token)
+				if err != nil {
+					return nil, err
+				}
+				// ❌ The token picks how the key material is interpreted
+				if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+					return jwt.ParseRSAPublicKeyFromPEM(key.Material)
+				}
+				return key.Material, nil
+			},
+		},
+		{
+			Name:        "kid-pinned",
+			Description: "kid lookup that requires the token alg to match the key alg",
+			KeyFunc: func(token *jwt.Token) (interface{}, error) {
+				key, err := r.lookup(token)
+				if err != nil {
+					return nil, err
+				}
+				if token.Method.Alg() != key.Alg {
+					return nil, fmt.Errorf("kid %v is a %v key, token uses %v", token.Header["kid"], key.Alg, token.Method.Alg())
+				}
+				return key.Parsed, nil
+			},
+		},
+	}
+}
+
+// confusionCase is one token the attacker or a legitimate issuer could present
+type confusionCase struct {
+	Name   string
+	Attack bool
+	Token  func(claims jwt.MapClaims) (string, error)
+	// Accepted lists the patterns expected to accept the token
+	Accepted []string
+}
+
+func (r *confusionRing) cases(secret []byte) ([]confusionCase, error) {
+	attackerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) func(jwt.MapClaims) (string, error) {
+		return func(claims jwt.MapClaims) (string, error) {
+			token := jwt.NewWithClaims(method, claims)
+			if kid != "" {
+				token.Header["kid"] = kid
+			}
+			return token.SignedString(key)
+		}
+	}
+
+	return []confusionCase{
+		{
+			Name:     "HS256 with the shared secret, kid hs-1",
+			Token:    sign(jwt.SigningMethodHS256, "hs-1", secret),
+			Accepted: []string{"demo", "kid-typed", "kid-bytes", "kid-pinned"},
+		},
+		{
+			Name:     "RS256 with the server key, kid rs-1",
+			Token:    sign(jwt.SigningMethodRS256, "rs-1", r.private),
+			Accepted: []string{"kid-typed", "kid-bytes", "kid-pinned"},
+		},
+		{
+			Name:   "alg none, kid hs-1",
+			Attack: true,
+			Token:  sign(jwt.SigningMethodNone, "hs-1", jwt.UnsafeAllowNoneSignatureType),
+		},
+		{
+			Name:   "alg none, kid rs-1",
+			Attack: true,
+			Token:  sign(jwt.SigningMethodNone, "rs-1", jwt.UnsafeAllowNoneSignatureType),
+		},
+		{
+			Name:   "alg none, no kid",
+			Attack: true,
+			Token:  sign(jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType),
+		},
+		{
+			Name:     "HS256 with the RSA public key PEM as secret, kid rs-1",
+			Attack:   true,
+			Token:    sign(jwt.SigningMethodHS256, "rs-1", r.pemKey),
+			Accepted: []string{"kid-bytes"},
+		},
+		{
+			Name:   "HS256 with the RSA public key PEM as secret, no kid",
+			Attack: true,
+			Token:  sign(jwt.SigningMethodHS256, "", r.pemKey),
+		},
+		{
+			Name:   "RS256 with an attacker key, kid hs-1",
+			Attack: true,
+			Token:  sign(jwt.SigningMethodRS256, "hs-1", attackerKey),
+		},
+		{
+			Name:   "RS256 with an attacker key, kid rs-1",
+			Attack: true,
+			Token:  sign(jwt.SigningMethodRS256, "rs-1", attackerKey),
+		},
+	}, nil
+}
+
+func confusionClaims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":  "attacker@evil.com",
+		"role": "admin",
+		"aud":  expectedAudience,
+		"iat":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+	}
+}
+
+// TestAlgorithmConfusion parses every token with every keyfunc pattern and reports which
+// patterns an attack gets through. The demo server is also called over HTTP, so its result
+// covers the whole request path of /api/protected and /api/protected-strict
+func TestAlgorithmConfusion(t *testing.T) {
+	srv := newPocServer(testKey)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	ring, err := newConfusionRing(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases, err := ring.cases(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := ring.patterns(srv)
+
+	exploitable := map[string][]string{}
+	for _, c := range cases {
+		token, err := c.Token(confusionClaims(time.Now()))
+		if err != nil {
+			t.Fatalf("[%v] Failed to sign token: %v", c.Name, err)
+		}
+
+		expected := map[string]bool{}
+		for _, name := range c.Accepted {
+			expected[name] = true
+		}
+
+		for _, pattern := range patterns {
+			_, err := jwt.Parse(token, pattern.KeyFunc)
+			accepted := err == nil
+			if accepted != expected[pattern.Name] {
+				t.Errorf("[%v] %v: expected accepted=%v, got error %v", c.Name, pattern.Name, expected[pattern.Name], err)
+			}
+			if c.Attack && accepted {
+				exploitable[pattern.Name] = append(exploitable[pattern.Name], c.Name)
+				t.Logf("🔥 [%v] %v accepted the forged token", c.Name, pattern.Name)
+			}
+		}
+
+		for _, path := range []string{"/api/protected", "/api/protected-strict"} {
+			result, _, err := callProtected(ts.URL+path, token)
+			if err != nil {
+				t.Fatalf("[%v] %v: %v", c.Name, path, err)
+			}
+			if result.Authorized != expected["demo"] {
+				t.Errorf("[%v] %v: expected authorized=%v, got %+v", c.Name, path, expected["demo"], result)
+			}
+		}
+	}
+
+	var report strings.Builder
+	for _, pattern := range patterns {
+		verdict := "not exploitable"
+		if attacks := exploitable[pattern.Name]; len(attacks) > 0 {
+			sort.Strings(attacks)
+			verdict = "🔥 EXPLOITABLE: " + strings.Join(attacks, "; ")
+		}
+		fmt.Fprintf(&report, "%-11s %s\n            %s\n", pattern.Name, pattern.Description, verdict)
+	}
+	t.Logf("Algorithm confusion results:\n%s", report.String())
+
+	if attacks := exploitable["demo"]; len(attacks) > 0 {
+		t.Errorf("❌ The demo server keyfunc is exploitable: %v", attacks)
+	}
+}
+
+// TestNoneAlgorithmVariants sends alg none tokens with the spellings and signatures other
+// libraries have accepted. jwt-go only knows "none", and only with UnsafeAllowNoneSignatureType
+func TestNoneAlgorithmVariants(t *testing.T) {
+	ts := httptest.NewServer(newPocServer(testKey).Handler())
+	defer ts.Close()
+
+	payload, err := jwt.NewWithClaims(jwt.SigningMethodNone, confusionClaims(time.Now())).
+		SigningString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimsPart := payload[strings.Index(payload, ".")+1:]
+
+	var testData = []struct {
+		alg       string
+		signature string
+	}{
+		{"none", ""},
+		{"None", ""},
+		{"NONE", ""},
+		{"nOnE", ""},
+		{"none", "AAAA"},
+		{"", ""},
+	}
+
+	for _, data := range testData {
+		header := jwt.EncodeSegment([]byte(fmt.Sprintf(`{"alg":%q,"typ":"JWT"}`, data.alg)))
+		token := header + "." + claimsPart + "." + data.signature
+
+		result, status, err := callProtected(ts.URL+"/api/protected", token)
+		if err != nil {
+			t.Fatalf("[%q] %v", data.alg, err)
+		}
+		if result.Authorized || status != http.StatusUnauthorized {
+			t.Errorf("🔥 [alg=%q sig=%q] expected status %d, got %d (%+v)", data.alg, data.signature, http.StatusUnauthorized, status, result)
+		}
+	}
+}
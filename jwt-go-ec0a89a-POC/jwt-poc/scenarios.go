@@ -0,0 +1,88 @@
+package main
+
+import (
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+)
+
+// expectedAudience is the audience the protected API checks for
+const expectedAudience = "protected-api"
+
+// scenario is one of the four token scenarios from POC_SUMMARY.txt
+type scenario struct {
+	Name        string
+	Description string
+	Subject     string
+	Role        string
+	// Audience is the aud claim, nil leaves the claim out of the token
+	Audience interface{}
+	// VulnerableAllows is whether VerifyAudience(expectedAudience, false) lets the token through
+	VulnerableAllows bool
+	// StrictAllows is whether the strict audience policy lets the token through
+	StrictAllows bool
+}
+
+var scenarios = []scenario{
+	{
+		Name:             "legit",
+		Description:      "场景1: 合法用户使用正确的 audience",
+		Subject:          "user@example.com",
+		Role:             "user",
+		Audience:         expectedAudience,
+		VulnerableAllows: true,
+		StrictAllows:     true,
+	},
+	{
+		Name:             "legacy",
+		Description:      "场景2: 老客户端不发送 audience (向后兼容)",
+		Subject:          "legacy@example.com",
+		Role:             "user",
+		Audience:         nil,
+		VulnerableAllows: true,
+		StrictAllows:     true,
+	},
+	{
+		Name:             "wrong-aud",
+		Description:      "场景3: 攻击者使用错误的 audience (被拦截)",
+		Subject:          "attacker@evil.com",
+		Role:             "admin",
+		Audience:         "other-api",
+		VulnerableAllows: false,
+		StrictAllows:     false,
+	},
+	{
+		Name:             "empty-aud",
+		Description:      "场景4: 攻击者使用空数组绕过验证",
+		Subject:          "attacker@evil.com",
+		Role:             "admin",
+		Audience:         []string{},
+		VulnerableAllows: true,
+		StrictAllows:     false,
+	},
+}
+
+// findScenario returns the scenario with the given name
+func findScenario(name string) (scenario, bool) {
+	for _, s := range scenarios {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return scenario{}, false
+}
+
+// issueToken signs an HS256 token for the scenario, valid for one hour from now
+func issueToken(s scenario, key []byte, now time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":  s.Subject,
+		"role": s.Role,
+		"iat":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+	}
+	if s.Audience != nil {
+		claims["aud"] = s.Audience
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
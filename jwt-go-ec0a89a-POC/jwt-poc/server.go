@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+	"github.com/dgrijalva/jwt-go/v4/audience"
+	"github.com/dgrijalva/jwt-go/v4/request"
+)
+
+// authResult is the JSON body returned by the protected endpoints
+type authResult struct {
+	Endpoint   string      `json:"endpoint"`
+	Authorized bool        `json:"authorized"`
+	Subject    string      `json:"subject,omitempty"`
+	Role       string      `json:"role,omitempty"`
+	Audience   interface{} `json:"aud"`
+	// Bypass is set when the token got in with an aud claim that does not name the API
+	Bypass bool   `json:"bypass"`
+	Error  string `json:"error,omitempty"`
+}
+
+// endpointStats counts the outcomes of one protected endpoint
+type endpointStats struct {
+	Allowed  int `json:"allowed"`
+	Denied   int `json:"denied"`
+	Bypasses int `json:"bypasses"`
+}
+
+// pocServer issues scenario tokens and serves the protected API twice: once with the
+// vulnerable VerifyAudience(..., false) check and once with the strict audience policy
+type pocServer struct {
+	key []byte
+	now func() time.Time
+
+	mu    sync.Mutex
+	stats map[string]*endpointStats
+}
+
+func newPocServer(key []byte) *pocServer {
+	return &pocServer{
+		key: key,
+		now: time.Now,
+		stats: map[string]*endpointStats{
+			"/api/protected":        {},
+			"/api/protected-strict": {},
+		},
+	}
+}
+
+// Handler returns the routes of the demo server
+func (s *pocServer) Handler() http.Handler {
+	strict := audience.AnyOf(expectedAudience)
+	strict.AllowAbsent = true
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/token", s.handleToken)
+	mux.HandleFunc("/stats", s.handleStats)
+	mux.Handle("/api/protected", s.protected("/api/protected", func(claims jwt.MapClaims) error {
+		// ❌ The vulnerable pattern from POC_README.md
+		if !claims.VerifyAudience(expectedAudience, false) {
+			return fmt.Errorf("audience verification failed")
+		}
+		return nil
+	}))
+	mux.Handle("/api/protected-strict", s.protected("/api/protected-strict", strict.VerifyMapClaims))
+	return mux
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>JWT Audience 绕过 POC</title></head>
+<body>
+<h1>JWT Audience 验证绕过 POC</h1>
+<p>期望的 audience: <code>{{.Audience}}</code></p>
+<table border="1" cellpadding="4">
+<tr><th>场景</th><th>Token</th><th>/api/protected</th><th>/api/protected-strict</th></tr>
+{{range .Scenarios}}<tr>
+<td>{{.Description}}</td>
+<td><a href="/token?scenario={{.Name}}">/token?scenario={{.Name}}</a></td>
+<td>{{if .VulnerableAllows}}通过{{else}}拦截{{end}}</td>
+<td>{{if .StrictAllows}}通过{{else}}拦截{{end}}</td>
+</tr>{{end}}
+</table>
+<pre>curl -H "Authorization: Bearer $TOKEN" http://127.0.0.1:8080/api/protected</pre>
+<p><a href="/stats">/stats</a></p>
+</body>
+</html>
+`))
+
+func (s *pocServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = indexTemplate.Execute(w, struct {
+		Audience  string
+		Scenarios []scenario
+	}{expectedAudience, scenarios})
+}
+
+func (s *pocServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("scenario")
+	sc, found := findScenario(name)
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown scenario %q", name)})
+		return
+	}
+
+	token, err := issueToken(sc, s.key, s.now())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"scenario":    sc.Name,
+		"description": sc.Description,
+		"token":       token,
+	})
+}
+
+// endpointStats returns a copy of the counters of one protected endpoint
+func (s *pocServer) endpointStats(endpoint string) endpointStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.stats[endpoint]
+}
+
+func (s *pocServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.stats)
+}
+
+// protected wraps an audience check into an endpoint that requires a valid HS256 bearer token
+func (s *pocServer) protected(endpoint string, verifyAudience func(jwt.MapClaims) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := authResult{Endpoint: endpoint}
+
+		tokenString, err := request.AuthorizationHeaderExtractor.ExtractToken(r)
+		if err != nil {
+			result.Error = err.Error()
+			s.record(result)
+			writeJSON(w, http.StatusUnauthorized, result)
+			return
+		}
+
+		token, err := jwt.Parse(tokenString, s.keyFunc)
+		if err != nil {
+			result.Error = err.Error()
+			s.record(result)
+			writeJSON(w, http.StatusUnauthorized, result)
+			return
+		}
+
+		claims := token.Claims.(jwt.MapClaims)
+		result.Subject, _ = claims["sub"].(string)
+		result.Role, _ = claims["role"].(string)
+		result.Audience = claims["aud"]
+
+		if err := verifyAudience(claims); err != nil {
+			result.Error = err.Error()
+			s.record(result)
+			writeJSON(w, http.StatusForbidden, result)
+			return
+		}
+
+		_, present := claims["aud"]
+		result.Authorized = true
+		result.Bypass = present && !claims.VerifyAudience(expectedAudience, true)
+		s.record(result)
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+// keyFunc only accepts HMAC tokens, the server has no public keys
+func (s *pocServer) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return s.key, nil
+}
+
+func (s *pocServer) record(result authResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats[result.Endpoint]
+	switch {
+	case result.Bypass:
+		stats.Bypasses++
+		stats.Allowed++
+	case result.Authorized:
+		stats.Allowed++
+	default:
+		stats.Denied++
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(body)
+}
+
+// checkLoopback rejects listen addresses that are reachable from other hosts
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("refusing to listen on %q, only loopback addresses are allowed", addr)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go/v4"
+)
+
+var testKey = []byte("your-secret-key")
+
+func TestScenarios(t *testing.T) {
+	srv := newPocServer(testKey)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	for _, sc := range scenarios {
+		token, err := fetchToken(ts.URL, sc.Name)
+		if err != nil {
+			t.Fatalf("[%v] Failed to fetch token: %v", sc.Name, err)
+		}
+
+		for _, endpoint := range []struct {
+			path   string
+			allows bool
+		}{
+			{"/api/protected", sc.VulnerableAllows},
+			{"/api/protected-strict", sc.StrictAllows},
+		} {
+			result, status, err := callProtected(ts.URL+endpoint.path, token)
+			if err != nil {
+				t.Fatalf("[%v] %v: %v", sc.Name, endpoint.path, err)
+			}
+
+			expectedStatus := http.StatusForbidden
+			if endpoint.allows {
+				expectedStatus = http.StatusOK
+			}
+			if status != expectedStatus || result.Authorized != endpoint.allows {
+				t.Errorf("[%v] %v: expected status %d, got %d (%+v)", sc.Name, endpoint.path, expectedStatus, status, result)
+			}
+			if result.Subject != sc.Subject {
+				t.Errorf("[%v] %v: expected subject %v, got %v", sc.Name, endpoint.path, sc.Subject, result.Subject)
+			}
+		}
+	}
+}
+
+func TestEmptyAudienceBypass(t *testing.T) {
+	srv := newPocServer(testKey)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	token, err := fetchToken(ts.URL, "empty-aud")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, err := callProtected(ts.URL+"/api/protected", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Authorized || !result.Bypass || result.Role != "admin" {
+		t.Fatalf("Expected the empty aud array to bypass the vulnerable endpoint as admin, got %+v", result)
+	}
+	t.Logf("🔥 aud=[] bypassed VerifyAudience(%q, false) as %s", expectedAudience, result.Subject)
+
+	result, _, err = callProtected(ts.URL+"/api/protected-strict", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Authorized {
+		t.Fatalf("Expected the strict endpoint to reject aud=[], got %+v", result)
+	}
+
+	stats := srv.endpointStats("/api/protected")
+	if stats.Bypasses != 1 || stats.Allowed != 1 {
+		t.Errorf("Expected one recorded bypass, got %+v", stats)
+	}
+}
+
+func TestInvalidTokens(t *testing.T) {
+	srv := newPocServer(testKey)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	sc, _ := findScenario("empty-aud")
+	now := time.Now()
+	wrongKey, _ := issueToken(sc, []byte("other-secret"), now)
+	expired, _ := issueToken(sc, testKey, now.Add(-2*time.Hour))
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "attacker@evil.com", "aud": []string{}}).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	var testData = []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"garbage", "not.a.token"},
+		{"wrong key", wrongKey},
+		{"expired", expired},
+		{"alg none", none},
+	}
+
+	for _, data := range testData {
+		for _, path := range []string{"/api/protected", "/api/protected-strict"} {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+			if data.token != "" {
+				req.Header.Set("Authorization", "Bearer "+data.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("[%v] %v: expected status %d, got %d", data.name, path, http.StatusUnauthorized, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func TestUnknownScenario(t *testing.T) {
+	ts := httptest.NewServer(newPocServer(testKey).Handler())
+	defer ts.Close()
+
+	if _, err := fetchToken(ts.URL, "nope"); err == nil {
+		t.Errorf("Expected an error for an unknown scenario")
+	}
+}
+
+func TestCheckLoopback(t *testing.T) {
+	var testData = []struct {
+		addr  string
+		valid bool
+	}{
+		{"127.0.0.1:8080", true},
+		{"localhost:8080", true},
+		{"[::1]:8080", true},
+		{"127.0.0.2:8080", true},
+		{":8080", false},
+		{"0.0.0.0:8080", false},
+		{"[::]:8080", false},
+		{"192.168.1.10:8080", false},
+		{"example.com:8080", false},
+		{"127.0.0.1", false},
+	}
+
+	for _, data := range testData {
+		err := checkLoopback(data.addr)
+		if (err == nil) != data.valid {
+			t.Errorf("[%v] Expected valid=%v, got error %v", data.addr, data.valid, err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	temporalMatrixOut = flag.String("temporal-matrix", "", "write the exp/nbf/iat verdict matrix to this file")
+	temporalNow       = flag.Int64("temporal-now", 1700000000, "unix time of the clock the temporal matrix runs at")
+)
+
+// temporalMeaning is the instant a shape is meant to express, relative to the clock
+type temporalMeaning string
+
+const (
+	meaningPast   temporalMeaning = "past"
+	meaningNow    temporalMeaning = "now"
+	meaningFuture temporalMeaning = "future"
+	// meaningNone is a shape that carries no usable instant
+	meaningNone temporalMeaning = "none"
+)
+
+// temporalShape is one representation of a time claim. Like audienceShape, JSON shapes are
+// decoded by the parser and Go shapes cover values only a Go issuer can produce
+type temporalShape struct {
+	Name    string
+	JSON    string
+	Value   interface{}
+	Meaning temporalMeaning
+	// Overflow marks numbers outside the int64 range. Decoded as float64, their conversion to
+	// int64 depends on the platform
+	Overflow bool
+}
+
+// temporalShapes generates every representation of a time claim around now
+func temporalShapes(now time.Time) []temporalShape {
+	past := now.Add(-time.Hour).Unix()
+	future := now.Add(time.Hour).Unix()
+	n := func(v int64) string { return fmt.Sprint(v) }
+
+	return []temporalShape{
+		{Name: "absent", Meaning: meaningNone},
+		{Name: "null", JSON: `null`, Meaning: meaningNone},
+		{Name: "past", JSON: n(past), Meaning: meaningPast},
+		{Name: "now", JSON: n(now.Unix()), Meaning: meaningNow},
+		{Name: "future", JSON: n(future), Meaning: meaningFuture},
+		{Name: "past fraction", JSON: n(past) + ".5", Meaning: meaningPast},
+		{Name: "future fraction", JSON: n(future) + ".5", Meaning: meaningFuture},
+		{Name: "past exponent", JSON: fmt.Sprintf("%e", float64(past)), Meaning: meaningPast},
+		{Name: "string past", JSON: `"` + n(past) + `"`, Meaning: meaningPast},
+		{Name: "string future", JSON: `"` + n(future) + `"`, Meaning: meaningFuture},
+		{Name: "string NaN", JSON: `"NaN"`, Meaning: meaningNone},
+		{Name: "string Infinity", JSON: `"Infinity"`, Meaning: meaningNone},
+		{Name: "RFC 3339 past", JSON: `"` + now.Add(-time.Hour).UTC().Format(time.RFC3339) + `"`, Meaning: meaningPast},
+		{Name: "zero", JSON: `0`, Meaning: meaningPast},
+		{Name: "negative", JSON: `-1`, Meaning: meaningPast},
+		{Name: "huge", JSON: `9999999999`, Meaning: meaningFuture},
+		{Name: "int64 overflow", JSON: `9223372036854775808`, Meaning: meaningFuture, Overflow: true},
+		{Name: "float overflow", JSON: `1e300`, Meaning: meaningFuture, Overflow: true},
+		{Name: "negative float overflow", JSON: `-1e300`, Meaning: meaningPast, Overflow: true},
+		{Name: "boolean", JSON: `true`, Meaning: meaningNone},
+		{Name: "array of past", JSON: `[` + n(past) + `]`, Meaning: meaningPast},
+		{Name: "array of future", JSON: `[` + n(future) + `]`, Meaning: meaningFuture},
+		{Name: "object", JSON: `{"exp":` + n(past) + `}`, Meaning: meaningPast},
+		{Name: "go int64 past", Value: past, Meaning: meaningPast},
+		{Name: "go int past", Value: int(past), Meaning: meaningPast},
+		{Name: "go float32 past", Value: float32(past), Meaning: meaningPast},
+		{Name: "go time past", Value: now.Add(-time.Hour), Meaning: meaningPast},
+	}
+}
+
+// temporalDecoding is how JSON shapes are decoded into MapClaims
+type temporalDecoding struct {
+	Name          string
+	UseJSONNumber bool
+}
+
+var temporalDecodings = []temporalDecoding{
+	{"float64", false},
+	{"json.Number", true},
+}
+
+// Claims stores the shape under claim in a fresh MapClaims, decoding JSON shapes as given
+func (shape temporalShape) Claims(claim string, decoding temporalDecoding) (MapClaims, error) {
+	claims := MapClaims{}
+	switch {
+	case shape.Value != nil:
+		claims[claim] = shape.Value
+	case shape.JSON != "":
+		dec := json.NewDecoder(bytes.NewBufferString(`{"` + claim + `":` + shape.JSON + `}`))
+		if decoding.UseJSONNumber {
+			dec.UseNumber()
+		}
+		if err := dec.Decode(&claims); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// temporalClaim is a time claim with its verify method and the instants it must reject
+type temporalClaim struct {
+	Name   string
+	Verify func(claims MapClaims, now int64, required bool) bool
+	// Rejects is the meaning that must fail verification, expired exp or not yet valid nbf/iat
+	Rejects temporalMeaning
+}
+
+var temporalClaims = []temporalClaim{
+	{"exp", func(c MapClaims, now int64, req bool) bool { return c.VerifyExpiresAt(now, req) }, meaningPast},
+	{"nbf", func(c MapClaims, now int64, req bool) bool { return c.VerifyNotBefore(now, req) }, meaningFuture},
+	{"iat", func(c MapClaims, now int64, req bool) bool { return c.VerifyIssuedAt(now, req) }, meaningFuture},
+}
+
+// temporalCell is the verdict of one claim, shape, decoding and required combination
+type temporalCell struct {
+	Decoding string
+	Required bool
+	Verdict  audienceVerdict
+}
+
+type temporalMatrixRow struct {
+	Claim temporalClaim
+	Shape temporalShape
+	Cells []temporalCell
+}
+
+func verifyTemporalVerdict(claim temporalClaim, claims MapClaims, now int64, required bool) (verdict audienceVerdict) {
+	defer func() {
+		if r := recover(); r != nil {
+			verdict = verdictPanic
+		}
+	}()
+
+	if claim.Verify(claims, now, required) {
+		return verdictAccept
+	}
+	return verdictReject
+}
+
+func buildTemporalMatrix(now time.Time) ([]temporalMatrixRow, error) {
+	var rows []temporalMatrixRow
+	for _, claim := range temporalClaims {
+		for _, shape := range temporalShapes(now) {
+			row := temporalMatrixRow{Claim: claim, Shape: shape}
+			for _, decoding := range temporalDecodings {
+				claims, err := shape.Claims(claim.Name, decoding)
+				if err != nil {
+					return nil, fmt.Errorf("%s %q: %v", claim.Name, shape.Name, err)
+				}
+				for _, required := range []bool{true, false} {
+					row.Cells = append(row.Cells, temporalCell{
+						Decoding: decoding.Name,
+						Required: required,
+						Verdict:  verifyTemporalVerdict(claim, claims, now.Unix(), required),
+					})
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// formatTemporalMatrix renders one block per claim in the same plain text layout as the
+// audience matrix
+func formatTemporalMatrix(now time.Time, rows []temporalMatrixRow) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Verify{ExpiresAt,NotBefore,IssuedAt}(%d, required)\n", now.Unix())
+
+	claim := ""
+	for _, row := range rows {
+		if row.Claim.Name != claim {
+			claim = row.Claim.Name
+			fmt.Fprintf(&b, "\n%-28s %-8s", claim, "meaning")
+			for i, cell := range row.Cells {
+				header := fmt.Sprintf("%s,req=%v", cell.Decoding, cell.Required)
+				if i == len(row.Cells)-1 {
+					fmt.Fprintf(&b, " %s\n", header)
+				} else {
+					fmt.Fprintf(&b, " %-22s", header)
+				}
+			}
+		}
+
+		fmt.Fprintf(&b, "%-28s %-8s", row.Shape.Name, row.Shape.Meaning)
+		for i, cell := range row.Cells {
+			if i == len(row.Cells)-1 {
+				fmt.Fprintf(&b, " %s\n", cell.Verdict)
+			} else {
+				fmt.Fprintf(&b, " %-22s", cell.Verdict)
+			}
+		}
+	}
+	return b.String()
+}
+
+// TestTemporalClaimMatrix runs the time claim verifiers on every shape and reports the verdict
+// matrix. Use -temporal-matrix=FILE to write it and -temporal-now=UNIX to move the clock
+func TestTemporalClaimMatrix(t *testing.T) {
+	now := time.Unix(*temporalNow, 0)
+	rows, err := buildTemporalMatrix(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matrix := formatTemporalMatrix(now, rows)
+	t.Logf("Verdict matrix:\n%s", matrix)
+
+	if *temporalMatrixOut != "" {
+		if err := ioutil.WriteFile(*temporalMatrixOut, []byte(matrix), 0644); err != nil {
+			t.Fatalf("failed to write matrix: %v", err)
+		}
+		t.Logf("Matrix written to %s", *temporalMatrixOut)
+	}
+
+	for _, row := range rows {
+		for _, cell := range row.Cells {
+			where := fmt.Sprintf("%s=%s (%s, required=%v)", row.Claim.Name, row.Shape.Name, cell.Decoding, cell.Required)
+			switch {
+			case cell.Verdict == verdictPanic:
+				t.Errorf("❌ Verifier panicked for %s", where)
+			case cell.Verdict != verdictAccept:
+			case row.Shape.Meaning == row.Claim.Rejects && cell.Required && row.Shape.Overflow && cell.Decoding == "float64":
+				// Reported, not failed: the verdict depends on how the platform converts out of
+				// range floats to int64
+				t.Logf("🔥🔥 %s is accepted although it is %s, even with required=true", where, row.Shape.Meaning)
+			case row.Shape.Meaning == row.Claim.Rejects && cell.Required:
+				t.Errorf("🔥🔥 %s is accepted although it is %s, even with required=true", where, row.Shape.Meaning)
+			case row.Shape.Meaning == meaningNone && cell.Required:
+				t.Errorf("🔥 %s is accepted although the claim is required", where)
+			case row.Shape.Meaning == row.Claim.Rejects && row.Shape.Name != "absent":
+				t.Logf("🔥 %s bypasses the %s check with required=false", where, row.Claim.Name)
+			}
+		}
+	}
+}
+
+// TestTemporalClockBoundary moves TimeFunc across exp, nbf and iat and checks Valid at the
+// boundaries, the same clock the parser uses
+func TestTemporalClockBoundary(t *testing.T) {
+	defer func(f func() time.Time) { TimeFunc = f }(TimeFunc)
+
+	instant := time.Unix(*temporalNow, 0)
+	var testData = []struct {
+		claim string
+		clock time.Duration
+		valid bool
+	}{
+		{"exp", -time.Second, true},
+		{"exp", 0, true},
+		{"exp", time.Second, false},
+		{"nbf", -time.Second, false},
+		{"nbf", 0, true},
+		{"nbf", time.Second, true},
+		{"iat", -time.Second, false},
+		{"iat", 0, true},
+		{"iat", time.Second, true},
+	}
+
+	for _, data := range testData {
+		clock := instant.Add(data.clock)
+		TimeFunc = func() time.Time { return clock }
+
+		for _, decoding := range temporalDecodings {
+			claims, err := temporalShape{JSON: fmt.Sprint(instant.Unix())}.Claims(data.claim, decoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid := claims.Valid() == nil; valid != data.valid {
+				t.Errorf("❌ %s=%d at clock %+v (%s): expected valid=%v, got %v",
+					data.claim, instant.Unix(), data.clock, decoding.Name, data.valid, valid)
+			}
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package jwt
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	timingEnabled = flag.Bool("timing", false, "run the audience timing harness, it takes a while")
+	timingSamples = flag.Int("timing-samples", 5000, "measurements per class in the audience timing harness")
+	timingSeed    = flag.Int64("timing-seed", 1, "seed for the class order of the audience timing harness")
+)
+
+// timingThreshold is the |t| above which dudect reports a leak
+const timingThreshold = 4.5
+
+// timingBatch is the number of calls timed together, to stay well above the timer resolution
+const timingBatch = 16
+
+// timingWarmup is the number of batches run before measuring, so that caches, branch predictors
+// and the CPU frequency settle first
+const timingWarmup = 1000
+
+// timingCropPercentiles are the upper cut-offs dudect applies to remove outliers caused by
+// interrupts and scheduling, 1 keeps every measurement
+var timingCropPercentiles = []float64{1, 0.9, 0.75, 0.5}
+
+// timingExperiment compares VerifyAudience on two classes of inputs that differ only in the
+// property under test. Both classes build fresh values of the same length on every call, so
+// allocation and cache effects hit them alike. Generators run before each timed batch, so only
+// the call is timed
+type timingExperiment struct {
+	Name   string
+	Expect string
+	A, B   func(rng *rand.Rand) MapClaims
+	// Known marks leaks that follow from the implementation, such as loops over the list
+	Known bool
+}
+
+// timingRandomString returns n random lowercase letters
+func timingRandomString(rng *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + rng.Intn(26))
+	}
+	return string(b)
+}
+
+// timingAudience is longer than matrixAudience so that prefix effects are measurable
+var timingAudience = strings.Repeat("protected-api-", 8)
+
+// timingVariant returns a fresh string of the length of timingAudience that shares its first
+// prefix bytes and continues with random lowercase letters. The audience ends in '-', so only
+// prefix == len(timingAudience) gives a match. Every byte is drawn either way, so generating
+// any variant takes the same work and leaves the same cache state behind
+func timingVariant(rng *rand.Rand, prefix int) string {
+	b := make([]byte, len(timingAudience))
+	for i := range b {
+		b[i] = byte('a' + rng.Intn(26))
+	}
+	copy(b, timingAudience[:prefix])
+	return string(b)
+}
+
+func timingList(values ...string) []interface{} {
+	list := make([]interface{}, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
+
+func timingExperiments() []timingExperiment {
+	wrongList := func(rng *rand.Rand, n int) []string {
+		values := make([]string, n)
+		for i := range values {
+			values[i] = timingVariant(rng, 0)
+		}
+		return values
+	}
+	prefix := len(timingAudience) - 1
+
+	return []timingExperiment{
+		{
+			Name:   "matching prefix vs random",
+			Expect: "constant, ConstantTimeCompare on equal lengths",
+			A: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingVariant(rng, prefix)}
+			},
+			B: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingVariant(rng, 0)}
+			},
+		},
+		{
+			Name:   "matching prefix vs random in list",
+			Expect: "constant, ConstantTimeCompare on equal lengths",
+			A: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingList(timingVariant(rng, prefix))}
+			},
+			B: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingList(timingVariant(rng, 0))}
+			},
+		},
+		{
+			Name:   "expected length vs other length",
+			Expect: "leaks the audience length, ConstantTimeCompare returns early on length mismatch",
+			A: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingRandomString(rng, len(timingAudience))}
+			},
+			B: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingRandomString(rng, 1)}
+			},
+			Known: true,
+		},
+		{
+			Name:   "short list vs long list",
+			Expect: "leaks the list length, every element is converted and compared",
+			A: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingList(wrongList(rng, 1)...)}
+			},
+			B: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingList(wrongList(rng, 64)...)}
+			},
+			Known: true,
+		},
+		{
+			Name:   "match first vs match last in list",
+			Expect: "leaks the match position, verifyAudList returns on the first match",
+			A: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingList(append([]string{timingVariant(rng, len(timingAudience))}, wrongList(rng, 31)...)...)}
+			},
+			B: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingList(append(wrongList(rng, 31), timingVariant(rng, len(timingAudience)))...)}
+			},
+			Known: true,
+		},
+		{
+			Name:   "match vs no match",
+			Expect: "constant for a single string",
+			A: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingVariant(rng, len(timingAudience))}
+			},
+			B: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": timingVariant(rng, 0)}
+			},
+		},
+		{
+			Name:   "empty array vs absent",
+			Expect: "leaks the shape, both end in !required but only the array walks the type switch",
+			A: func(rng *rand.Rand) MapClaims {
+				return MapClaims{"aud": []interface{}{}}
+			},
+			B: func(rng *rand.Rand) MapClaims {
+				return MapClaims{}
+			},
+			Known: true,
+		},
+	}
+}
+
+// timingResult is the outcome of one experiment
+type timingResult struct {
+	Experiment timingExperiment
+	// T is the largest |t| over all crop percentiles
+	T            float64
+	MeanA, MeanB time.Duration
+	Samples      int
+}
+
+func (r timingResult) Leaks() bool {
+	return r.T > timingThreshold
+}
+
+// welchT returns Welch's t statistic for two samples
+func welchT(a, b []float64) float64 {
+	meanVar := func(xs []float64) (float64, float64) {
+		var mean float64
+		for _, x := range xs {
+			mean += x
+		}
+		mean /= float64(len(xs))
+		var variance float64
+		for _, x := range xs {
+			variance += (x - mean) * (x - mean)
+		}
+		return mean, variance / float64(len(xs)-1)
+	}
+
+	if len(a) < 2 || len(b) < 2 {
+		return 0
+	}
+	meanA, varA := meanVar(a)
+	meanB, varB := meanVar(b)
+	denominator := math.Sqrt(varA/float64(len(a)) + varB/float64(len(b)))
+	if denominator == 0 {
+		if meanA == meanB {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (meanA - meanB) / denominator
+}
+
+// cropBelow returns the measurements below the percentile of all measurements
+func cropBelow(xs []float64, threshold float64) []float64 {
+	var cropped []float64
+	for _, x := range xs {
+		if x <= threshold {
+			cropped = append(cropped, x)
+		}
+	}
+	return cropped
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if p >= 1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// measureTiming runs the two classes in a random interleaved order, as dudect does, so that
+// drift in the machine state affects both classes alike. Each batch is generated right before
+// it is timed, so memory stays at one batch however many samples are taken
+func measureTiming(experiment timingExperiment, verify func(MapClaims) bool, samples int, rng *rand.Rand) timingResult {
+	runtime.GC()
+	var a, b []float64
+	var inputs [timingBatch]MapClaims
+	sink := false
+	for i := -timingWarmup; i < 2*samples; i++ {
+		classA := rng.Intn(2) == 0
+		generate := experiment.B
+		if classA {
+			generate = experiment.A
+		}
+		for j := range inputs {
+			inputs[j] = generate(rng)
+		}
+
+		start := time.Now()
+		for j := range inputs {
+			sink = verify(inputs[j]) != sink
+		}
+		elapsed := float64(time.Since(start).Nanoseconds()) / timingBatch
+
+		switch {
+		case i < 0:
+		case classA:
+			a = append(a, elapsed)
+		default:
+			b = append(b, elapsed)
+		}
+	}
+	_ = sink
+
+	all := append(append([]float64{}, a...), b...)
+	sort.Float64s(all)
+
+	result := timingResult{Experiment: experiment, Samples: 2 * samples}
+	for _, p := range timingCropPercentiles {
+		threshold := percentile(all, p)
+		if t := math.Abs(welchT(cropBelow(a, threshold), cropBelow(b, threshold))); t > result.T {
+			result.T = t
+		}
+	}
+	mean := func(xs []float64) time.Duration {
+		var sum float64
+		for _, x := range xs {
+			sum += x
+		}
+		return time.Duration(sum / float64(len(xs)))
+	}
+	result.MeanA, result.MeanB = mean(a), mean(b)
+	return result
+}
+
+func verifyAudienceForTiming(claims MapClaims) bool {
+	return claims.VerifyAudience(timingAudience, false)
+}
+
+// TestAudienceTimingHarness runs every experiment and reports |t| per experiment. Leaks that
+// follow from the list loop are expected, any other leak is reported with 🔥. The harness only
+// measures this process and needs no network. It only runs with -timing, use -timing-samples to
+// trade time for power
+func TestAudienceTimingHarness(t *testing.T) {
+	if !*timingEnabled {
+		t.Skip("timing measurements take a while, enable them with -timing")
+	}
+	rng := rand.New(rand.NewSource(*timingSeed))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-36s %8s %10s %10s  %s\n", "experiment", "|t|", "mean A", "mean B", "verdict")
+	for _, experiment := range timingExperiments() {
+		result := measureTiming(experiment, verifyAudienceForTiming, *timingSamples, rng)
+
+		// A burst of load on the machine can push a single run over the threshold, an unexpected
+		// leak only counts when a second measurement shows it as well
+		var rerun timingResult
+		if result.Leaks() && !experiment.Known {
+			rerun = measureTiming(experiment, verifyAudienceForTiming, *timingSamples, rng)
+		}
+
+		verdict := "no leak detected"
+		switch {
+		case result.Leaks() && experiment.Known:
+			verdict = "LEAK"
+			t.Logf("⚠️  %s: |t|=%.2f, expected: %s", experiment.Name, result.T, experiment.Expect)
+		case result.Leaks() && rerun.Leaks():
+			verdict = "LEAK"
+			t.Logf("🔥 %s: |t|=%.2f and %.2f on the rerun, measurable leakage where the expectation is %q",
+				experiment.Name, result.T, rerun.T, experiment.Expect)
+		case result.Leaks():
+			verdict = fmt.Sprintf("noise, |t|=%.2f on the rerun", rerun.T)
+		}
+		fmt.Fprintf(&b, "%-36s %8.2f %10v %10v  %s\n", experiment.Name, result.T, result.MeanA, result.MeanB, verdict)
+	}
+	t.Logf("Welch's t-test, threshold |t| > %.1f, %d samples per class:\n%s", timingThreshold, *timingSamples, b.String())
+}
+
+// TestTimingHarnessDetectsLeak checks that the harness flags an obvious data dependent loop
+func TestTimingHarnessDetectsLeak(t *testing.T) {
+	if !*timingEnabled {
+		t.Skip("timing measurements take a while, enable them with -timing")
+	}
+
+	leaky := func(claims MapClaims) bool {
+		aud, _ := claims["aud"].(string)
+		equal := true
+		for i := 0; i < len(aud) && i < len(timingAudience); i++ {
+			if aud[i] != timingAudience[i] {
+				equal = false
+				break
+			}
+		}
+		return equal
+	}
+	experiment := timingExperiment{
+		Name: "early exit compare",
+		A:    func(rng *rand.Rand) MapClaims { return MapClaims{"aud": timingVariant(rng, len(timingAudience)-1)} },
+		B:    func(rng *rand.Rand) MapClaims { return MapClaims{"aud": timingVariant(rng, 0)} },
+	}
+
+	result := measureTiming(experiment, leaky, 5000, rand.New(rand.NewSource(*timingSeed)))
+	t.Logf("|t|=%.2f, mean A %v, mean B %v", result.T, result.MeanA, result.MeanB)
+	if !result.Leaks() {
+		t.Errorf("❌ The harness did not detect an early exit comparison over %d bytes", len(timingAudience))
+	}
+}
+
+func TestWelchT(t *testing.T) {
+	same := []float64{1, 2, 3, 4, 5}
+	if v := welchT(same, same); v != 0 {
+		t.Errorf("❌ Expected t=0 for identical samples, got %v", v)
+	}
+	if v := welchT([]float64{10, 11, 10, 11}, []float64{1, 2, 1, 2}); v < 10 {
+		t.Errorf("❌ Expected a large t for separated samples, got %v", v)
+	}
+	if v := welchT([]float64{1}, []float64{2, 3}); v != 0 {
+		t.Errorf("❌ Expected t=0 for too few samples, got %v", v)
+	}
+}
@@ -0,0 +1,201 @@
+package shoutrrr
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// injectionVector is user controlled text that means something to Discord when it is not escaped
+type injectionVector struct {
+	Name     string
+	Category string
+	Text     string
+}
+
+// injectionVectors are the strings an alerting pipeline could forward from its users
+var injectionVectors = []injectionVector{
+	{"@everyone", "mention", "@everyone please check"},
+	{"@here", "mention", "@here please check"},
+	{"Role mention", "mention", "ping <@&123456789012345678>"},
+	{"User mention", "mention", "ping <@123456789012345678>"},
+	{"Nickname mention", "mention", "ping <@!123456789012345678>"},
+	{"Channel link", "mention", "see <#123456789012345678>"},
+	{"Emphasis", "markdown", "**bold** __underline__ ~~strike~~ *italic*"},
+	{"Spoiler", "markdown", "||hidden until clicked||"},
+	{"Inline code", "markdown", "`rm -rf /`"},
+	{"Heading", "markdown", "# Server compromised"},
+	{"Code block", "markdown", "```\nfake log output\n```"},
+	{"Block quote", "markdown", "> Message from the admins"},
+	{"Masked link", "link", "[https://example.com/login](https://attacker.example/phish)"},
+	{"Masked link without preview", "link", "[reset your password](<https://attacker.example/reset>)"},
+	{"Suppressed preview", "link", "<https://attacker.example/no-preview>"},
+	{"Userinfo in URL", "link", "https://example.com@attacker.example/login"},
+	{"Long URL", "long url", "https://attacker.example/" + strings.Repeat("a", 200)},
+	{"URL over the chunk size", "long url", "https://attacker.example/" + strings.Repeat("a", 2100)},
+}
+
+// injectionSurface is where the vector is put into a shoutrrr.Send call
+type injectionSurface struct {
+	Name string
+	// send returns the query and message that place text on this surface
+	send func(text string) (query string, message string)
+}
+
+// injectionControl is the message sent when the vector is in the title
+const injectionControl = "injection audit"
+
+var injectionSurfaces = []injectionSurface{
+	{"message", func(text string) (string, string) { return "", text }},
+	{"message, splitlines=no", func(text string) (string, string) { return "splitlines=no", text }},
+	{"title", func(text string) (string, string) { return "title=" + url.QueryEscape(text), injectionControl }},
+}
+
+// pingingMention matches the mentions Discord notifies for when they are in the content field
+var pingingMention = regexp.MustCompile(`@everyone|@here|<@[!&]?\d+>`)
+
+// injectionOutcome is how a vector arrived at the stand-in
+type injectionOutcome int
+
+const (
+	// injectionVerbatim means the text reached a field unescaped and in one piece
+	injectionVerbatim injectionOutcome = iota
+	// injectionAltered means the message was delivered, but the text was split or truncated
+	injectionAltered
+	// injectionRejected means Discord refused the payload, the notification is dropped
+	injectionRejected
+	// injectionError means shoutrrr refused to send
+	injectionError
+	// injectionPanic means the send panicked
+	injectionPanic
+)
+
+func (outcome injectionOutcome) Symbol() string {
+	switch outcome {
+	case injectionVerbatim:
+		return "V"
+	case injectionAltered:
+		return "~"
+	case injectionRejected:
+		return "R"
+	case injectionError:
+		return "E"
+	case injectionPanic:
+		return "P"
+	}
+	return "?"
+}
+
+// injectionResult is the audit of one vector on one surface
+type injectionResult struct {
+	Outcome injectionOutcome
+	// Fields are the payload fields the text arrived in unescaped, like "embeds.1.description"
+	Fields []string
+	// Pings is set when a mention arrived in the content field without allowed_mentions
+	Pings bool
+}
+
+// auditInjection classifies what the stand-in received for text
+func auditInjection(result sendResult, text string) injectionResult {
+	switch result.Outcome() {
+	case sendPanic:
+		return injectionResult{Outcome: injectionPanic}
+	case sendRejected:
+		return injectionResult{Outcome: injectionRejected}
+	case sendRefused, sendInconsistent:
+		return injectionResult{Outcome: injectionError}
+	}
+
+	audit := injectionResult{Outcome: injectionAltered}
+	for _, req := range result.Requests {
+		if strings.Contains(req.Message.Content, text) {
+			audit.Fields = append(audit.Fields, "content")
+		}
+		if pingingMention.MatchString(req.Message.Content) && !bytes.Contains(req.Body, []byte(`"allowed_mentions"`)) {
+			audit.Pings = true
+		}
+		for i, embed := range req.Message.Embeds {
+			if strings.Contains(embed.Title, text) {
+				audit.Fields = append(audit.Fields, fmt.Sprintf("embeds.%d.title", i))
+			}
+			if strings.Contains(embed.Content, text) {
+				audit.Fields = append(audit.Fields, fmt.Sprintf("embeds.%d.description", i))
+			}
+		}
+	}
+	if len(audit.Fields) > 0 {
+		audit.Outcome = injectionVerbatim
+	}
+	return audit
+}
+
+// TestContentInjectionAudit sends mentions, markdown, masked links and long URLs through the
+// discord service and reports which of them reach the channel unescaped. Discord renders
+// mentions, markdown and masked links in embed descriptions, but only notifies for mentions in
+// the content field, which the discord service never sets
+func TestContentInjectionAudit(t *testing.T) {
+	standIn := newWebhookStandIn(t)
+
+	control := sendRecovering(standIn, func() error { return Send(standIn.URL(""), injectionControl) })
+	if control.Outcome() != sendDelivered || !requestsContain(control.Requests, injectionControl) {
+		t.Fatalf("❌ Control message was not delivered (%v, error: %v), the stand-in setup is broken", control.Outcome(), control.Err)
+	}
+
+	outcomes := make([][]injectionOutcome, len(injectionVectors))
+	verbatim := map[string]int{}
+	for i, vector := range injectionVectors {
+		i, vector := i, vector
+		t.Run(vector.Name, func(t *testing.T) {
+			for _, surface := range injectionSurfaces {
+				query, message := surface.send(vector.Text)
+				result := sendRecovering(standIn, func() error { return Send(standIn.URL(query), message) })
+				audit := auditInjection(result, vector.Text)
+				outcomes[i] = append(outcomes[i], audit.Outcome)
+
+				switch audit.Outcome {
+				case injectionVerbatim:
+					verbatim[vector.Category]++
+					t.Logf("🚨 %s: %s arrives unescaped in %s", surface.Name, vector.Category, strings.Join(audit.Fields, ", "))
+				case injectionAltered:
+					t.Logf("⚠️  %s: delivered, but not in one piece (%d requests)", surface.Name, len(result.Requests))
+				case injectionRejected:
+					t.Logf("🚨 %s: Discord refused the payload, the notification is dropped: %v", surface.Name, result.Requests[len(result.Requests)-1].Error)
+				case injectionError:
+					t.Logf("%s: shoutrrr refused to send: %v", surface.Name, result.Err)
+				case injectionPanic:
+					t.Errorf("❌ %s: unexpected panic\n%s", surface.Name, result.CrashReport)
+				}
+
+				if audit.Pings {
+					t.Errorf("❌ %s: the mention is in the content field without allowed_mentions and notifies the channel", surface.Name)
+				}
+			}
+		})
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "%-30s", "vector")
+	for _, surface := range injectionSurfaces {
+		fmt.Fprintf(&report, " %-24s", surface.Name)
+	}
+	report.WriteString("\n")
+	for i, vector := range injectionVectors {
+		fmt.Fprintf(&report, "%-30s", vector.Name)
+		for _, outcome := range outcomes[i] {
+			fmt.Fprintf(&report, " %-24s", outcome.Symbol())
+		}
+		report.WriteString("\n")
+	}
+	t.Logf("Content injection audit (V unescaped, ~ split or truncated, R refused by Discord, E error, P panic):\n%s", report.String())
+
+	var categories []string
+	for category, count := range verbatim {
+		categories = append(categories, fmt.Sprintf("%s: %d", category, count))
+	}
+	sort.Strings(categories)
+	t.Logf("Unescaped arrivals per category: %s", strings.Join(categories, ", "))
+}
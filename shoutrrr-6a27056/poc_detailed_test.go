@@ -41,88 +41,130 @@ func TestPartitionMessageBehavior(t *testing.T) {
 	}
 }
 
+// createPayloadCases are the direct CreatePayloadFromItems inputs of the PoC, with the outcome
+// expected in each mode
+var createPayloadCases = []struct {
+	name        string
+	items       []types.MessageItem
+	title       string
+	omitted     int
+	vulnerable  payloadOutcome
+	fixed       payloadOutcome
+	description string
+}{
+	{
+		name:        "Empty items array",
+		items:       []types.MessageItem{},
+		title:       "Test Title",
+		omitted:     0,
+		vulnerable:  outcomePayload,
+		fixed:       outcomeEmptyError,
+		description: "The title creates a meta embed, so the vulnerable code sends a payload without content",
+	},
+	{
+		name:        "Nil items",
+		items:       nil,
+		title:       "Test Title",
+		omitted:     0,
+		vulnerable:  outcomePayload,
+		fixed:       outcomeEmptyError,
+		description: "Same as the empty array, nil items must be rejected by the fix",
+	},
+	{
+		name:        "Empty items array without title",
+		items:       []types.MessageItem{},
+		title:       "",
+		omitted:     0,
+		vulnerable:  outcomePanic,
+		fixed:       outcomeEmptyError,
+		description: "Should panic when accessing embeds[0] with no items and no meta embed",
+	},
+	{
+		name:        "Nil items without title",
+		items:       nil,
+		title:       "",
+		omitted:     0,
+		vulnerable:  outcomePanic,
+		fixed:       outcomeEmptyError,
+		description: "Should panic when items is nil and there is no meta embed",
+	},
+	{
+		name: "Single empty item",
+		items: []types.MessageItem{
+			{Text: ""},
+		},
+		title:       "Test Title",
+		omitted:     0,
+		vulnerable:  outcomePayload,
+		fixed:       outcomePayload,
+		description: "Should handle single empty item",
+	},
+	{
+		name: "Normal item",
+		items: []types.MessageItem{
+			{Text: "Hello World"},
+		},
+		title:       "Test Title",
+		omitted:     0,
+		vulnerable:  outcomePayload,
+		fixed:       outcomePayload,
+		description: "Should handle normal item",
+	},
+}
+
 // TestCreatePayloadWithVariousInputs tests CreatePayloadFromItems with different inputs
+// The expected outcome of each case depends on the expectation mode (see currentExpectation)
 func TestCreatePayloadWithVariousInputs(t *testing.T) {
 	colors := [types.MessageLevelCount]uint{0xFF0000, 0x00FF00, 0x0000FF, 0xFFFF00, 0xFF00FF}
+	mode := currentExpectation(t)
+	t.Logf("Expectation mode: %s", mode)
 
-	testCases := []struct {
-		name        string
-		items       []types.MessageItem
-		title       string
-		omitted     int
-		shouldPanic bool
-		description string
-	}{
-		{
-			name:        "Empty items array",
-			items:       []types.MessageItem{},
-			title:       "Test Title",
-			omitted:     0,
-			shouldPanic: true,
-			description: "Should panic when accessing embeds[0] with no items",
-		},
-		{
-			name:        "Nil items",
-			items:       nil,
-			title:       "Test Title",
-			omitted:     0,
-			shouldPanic: true,
-			description: "Should panic when items is nil",
-		},
-		{
-			name: "Single empty item",
-			items: []types.MessageItem{
-				{Text: ""},
-			},
-			title:       "Test Title",
-			omitted:     0,
-			shouldPanic: false,
-			description: "Should handle single empty item",
-		},
-		{
-			name: "Normal item",
-			items: []types.MessageItem{
-				{Text: "Hello World"},
-			},
-			title:       "Test Title",
-			omitted:     0,
-			shouldPanic: false,
-			description: "Should handle normal item",
-		},
-	}
-
-	for _, tc := range testCases {
+	for _, tc := range createPayloadCases {
 		t.Run(tc.name, func(t *testing.T) {
-			defer func() {
-				if r := recover(); r != nil {
-					if tc.shouldPanic {
-						t.Logf("✅ Expected panic occurred: %v", r)
-						t.Logf("   Description: %s", tc.description)
-						t.Logf("🚨 VULNERABILITY CONFIRMED!")
-					} else {
-						t.Errorf("❌ Unexpected panic: %v", r)
-					}
-				} else {
-					if tc.shouldPanic {
-						t.Errorf("❌ Expected panic but didn't get one")
-						t.Logf("   The code may have defensive checks we didn't account for")
-					} else {
-						t.Logf("✅ No panic as expected")
-					}
-				}
-			}()
-
-			payload, err := discord.CreatePayloadFromItems(tc.items, tc.title, colors, tc.omitted)
+			expected := tc.vulnerable
+			if mode == expectFixed {
+				expected = tc.fixed
+			}
 
-			if err != nil {
-				t.Logf("Error returned: %v", err)
-			} else {
-				t.Logf("Payload created: Embeds count = %d", len(payload.Embeds))
+			result := createPayloadRecovering(tc.items, tc.title, colors, tc.omitted)
+			actual := result.Outcome()
+
+			switch actual {
+			case outcomePanic:
+				t.Logf("Panic occurred: %v", result.Panic)
+			case outcomePayload:
+				t.Logf("Payload created: Embeds count = %d", len(result.Payload.Embeds))
+			default:
+				t.Logf("Error returned: %v", result.Err)
+			}
+
+			if actual != expected {
+				t.Errorf("❌ Expected %v in %s mode, got %v", expected, mode, actual)
+				t.Logf("   Description: %s", tc.description)
+				return
+			}
+
+			t.Logf("✅ Got %v as expected", actual)
+			if actual == outcomePanic {
+				t.Logf("   Description: %s", tc.description)
+				t.Logf("🚨 VULNERABILITY CONFIRMED!")
 			}
 		})
 	}
 }
 
+// emptyMessageAttacks are messages without visible content, used wherever an empty message
+// could reach a payload builder
+var emptyMessageAttacks = []string{
+	"",                         // Direct empty
+	" ",                        // Single space
+	"\n",                       // Newline
+	"\t",                       // Tab
+	"   \n\t  ",                // Mixed whitespace
+	"\u200B",                   // Zero-width space
+	"\u200B\u200C\u200D\uFEFF", // Multiple zero-width chars
+}
+
 // TestRealWorldScenario simulates a real attack scenario
 func TestRealWorldScenario(t *testing.T) {
 	t.Log("=== SIMULATING REAL-WORLD ATTACK SCENARIO ===")
@@ -145,19 +187,8 @@ func TestRealWorldScenario(t *testing.T) {
 		ChunkCount:     10,
 	}
 
-	// Test different "empty" messages
-	attacks := []string{
-		"",                         // Direct empty
-		" ",                        // Single space
-		"\n",                       // Newline
-		"\t",                       // Tab
-		"   \n\t  ",                // Mixed whitespace
-		"\u200B",                   // Zero-width space
-		"\u200B\u200C\u200D\uFEFF", // Multiple zero-width chars
-	}
-
 	t.Log("Scenario 3: Testing various empty message attacks:")
-	for i, attack := range attacks {
+	for i, attack := range emptyMessageAttacks {
 		items, omitted := util.PartitionMessage(attack, limits, 100)
 		t.Logf("  Attack %d: %q (runes=%d)", i+1, attack, len([]rune(attack)))
 		t.Logf("    Result: %d items, %d omitted", len(items), omitted)
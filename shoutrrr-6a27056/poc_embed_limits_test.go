@@ -0,0 +1,346 @@
+package shoutrrr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/containrrr/shoutrrr/pkg/services/discord"
+)
+
+// Limits of the Discord execute webhook API, see https://discord.com/developers/docs/resources/message#embed-object-embed-limits
+const (
+	discordMaxContent         = 2000
+	discordMaxUsername        = 80
+	discordMaxEmbeds          = 10
+	discordMaxTitle           = 256
+	discordMaxDescription     = 4096
+	discordMaxFooterText      = 2048
+	discordMaxTotalCharacters = 6000
+	discordMaxColor           = 0xFFFFFF
+)
+
+// discordReservedUsernames may not appear in a webhook username
+var discordReservedUsernames = []string{"clyde", "discord"}
+
+// embedSizeExceeded starts the reason Discord gives when all embeds together are too long
+const embedSizeExceeded = "Embed size exceeds maximum size of"
+
+// limitViolation is one reason the Discord API would refuse a message
+type limitViolation struct {
+	// Field is the path Discord reports the error under, like "embeds.0.title"
+	Field  string
+	Reason string
+}
+
+func (violation limitViolation) String() string {
+	return fmt.Sprintf("%s: %s", violation.Field, violation.Reason)
+}
+
+// exceedsEmbedTotal reports whether the violation is the total size of all embeds
+func (violation limitViolation) exceedsEmbedTotal() bool {
+	return strings.HasPrefix(violation.Reason, embedSizeExceeded)
+}
+
+// omittedFooterSuffix ends the footer CreatePayloadFromItems adds when runes were omitted
+const omittedFooterSuffix = "character(s) where omitted)"
+
+// omittedFooterOverflow reports whether the embeds only exceed the total because of the first
+// embed, which carries the title and the omitted footer. PartitionMessage fills the whole total
+// with the message, so the first embed goes over it in both modes
+func omittedFooterOverflow(payload discord.WebhookPayload) bool {
+	var message webhookMessage
+	body, err := json.Marshal(payload)
+	if err != nil || json.Unmarshal(body, &message) != nil || len(message.Embeds) == 0 {
+		return false
+	}
+
+	total, meta := 0, utf8.RuneCountInString(message.Embeds[0].Title)
+	for _, embed := range message.Embeds {
+		total += utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Content)
+		if embed.Footer == nil {
+			continue
+		}
+		total += utf8.RuneCountInString(embed.Footer.Text)
+		if strings.HasSuffix(embed.Footer.Text, omittedFooterSuffix) {
+			meta += utf8.RuneCountInString(embed.Footer.Text)
+		}
+	}
+	return total > discordMaxTotalCharacters && total-meta <= discordMaxTotalCharacters
+}
+
+// formErrors turns violations into the errors object of an "Invalid Form Body" response
+func formErrors(violations []limitViolation) map[string]string {
+	errors := map[string]string{}
+	for _, violation := range violations {
+		if previous, found := errors[violation.Field]; found {
+			errors[violation.Field] = previous + " " + violation.Reason
+			continue
+		}
+		errors[violation.Field] = violation.Reason
+	}
+	return errors
+}
+
+// validateWebhookPayload checks a payload against the Discord limits offline
+func validateWebhookPayload(payload discord.WebhookPayload) []limitViolation {
+	return validateWebhookMessage(webhookMessage{WebhookPayload: payload})
+}
+
+// validateWebhookMessage checks a webhook message against the Discord limits. An empty message
+// is not a limit violation, see isEmptyWebhookMessage
+func validateWebhookMessage(message webhookMessage) []limitViolation {
+	var violations []limitViolation
+	maxLength := func(field string, value string, max int) {
+		if length := utf8.RuneCountInString(value); length > max {
+			violations = append(violations, limitViolation{field, fmt.Sprintf("Must be %d or fewer in length (got %d).", max, length)})
+		}
+	}
+	wellFormedURL := func(field string, value string) {
+		if value == "" {
+			return
+		}
+		if parsed, err := url.Parse(value); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			violations = append(violations, limitViolation{field, "Not a well formed URL."})
+		}
+	}
+
+	maxLength("content", message.Content, discordMaxContent)
+
+	maxLength("username", message.Username, discordMaxUsername)
+	for _, reserved := range discordReservedUsernames {
+		if strings.Contains(strings.ToLower(message.Username), reserved) {
+			violations = append(violations, limitViolation{"username", fmt.Sprintf("Username cannot contain %q.", reserved)})
+		}
+	}
+	wellFormedURL("avatar_url", message.AvatarURL)
+
+	if len(message.Embeds) > discordMaxEmbeds {
+		violations = append(violations, limitViolation{"embeds", fmt.Sprintf("Must be %d or fewer in length (got %d).", discordMaxEmbeds, len(message.Embeds))})
+	}
+
+	total := 0
+	for i, embed := range message.Embeds {
+		field := fmt.Sprintf("embeds.%d", i)
+
+		maxLength(field+".title", embed.Title, discordMaxTitle)
+		maxLength(field+".description", embed.Content, discordMaxDescription)
+		wellFormedURL(field+".url", embed.URL)
+		total += utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Content)
+
+		if embed.Color > discordMaxColor {
+			violations = append(violations, limitViolation{field + ".color", fmt.Sprintf("Int value should be less than or equal to %d.", discordMaxColor)})
+		}
+		if embed.Timestamp != "" {
+			if _, err := time.Parse(time.RFC3339, embed.Timestamp); err != nil {
+				violations = append(violations, limitViolation{field + ".timestamp", "Could not parse " + embed.Timestamp + ". Should be ISO8601."})
+			}
+		}
+		if embed.Footer != nil {
+			maxLength(field+".footer.text", embed.Footer.Text, discordMaxFooterText)
+			wellFormedURL(field+".footer.icon_url", embed.Footer.IconURL)
+			total += utf8.RuneCountInString(embed.Footer.Text)
+		}
+	}
+	if total > discordMaxTotalCharacters {
+		violations = append(violations, limitViolation{"embeds", fmt.Sprintf("%s %d (got %d).", embedSizeExceeded, discordMaxTotalCharacters, total)})
+	}
+
+	return violations
+}
+
+// TestWebhookPayloadValidator checks the validator on hand written payloads. The embed type is
+// not exported, so the payloads are decoded from JSON like the stand-in does
+func TestWebhookPayloadValidator(t *testing.T) {
+	embed := func(fields string) string { return "{" + fields + "}" }
+	repeat := func(n int) string { return strings.Repeat("x", n) }
+
+	testCases := []struct {
+		name   string
+		json   string
+		fields []string
+	}{
+		{"Valid", `{"embeds":[` + embed(`"title":"t","description":"d","color":16777215,"footer":{"text":"f"}`) + `]}`, nil},
+		{"Title at the limit in runes", `{"embeds":[` + embed(`"title":"`+strings.Repeat("é", 256)+`"`) + `]}`, nil},
+		{"Title too long", `{"embeds":[` + embed(`"title":"`+repeat(257)+`"`) + `]}`, []string{"embeds.0.title"}},
+		{"Description too long", `{"embeds":[` + embed(`"description":"`+repeat(4097)+`"`) + `]}`, []string{"embeds.0.description"}},
+		{"Footer too long", `{"embeds":[` + embed(`"description":"d","footer":{"text":"`+repeat(2049)+`"}`) + `]}`, []string{"embeds.0.footer.text"}},
+		{"Eleven embeds", `{"embeds":[` + strings.Repeat(embed(`"title":"t"`)+",", 10) + embed(`"title":"t"`) + `]}`, []string{"embeds"}},
+		{"Total over 6000", `{"embeds":[` + embed(`"description":"`+repeat(4000)+`"`) + "," + embed(`"description":"`+repeat(2001)+`"`) + `]}`, []string{"embeds"}},
+		{"Content too long", `{"content":"` + repeat(2001) + `"}`, []string{"content"}},
+		{"Username too long", `{"content":"c","username":"` + repeat(81) + `"}`, []string{"username"}},
+		{"Reserved username", `{"content":"c","username":"Discord Alerts"}`, []string{"username"}},
+		{"Color out of range", `{"embeds":[` + embed(`"title":"t","color":16777216`) + `]}`, []string{"embeds.0.color"}},
+		{"Bad timestamp", `{"embeds":[` + embed(`"title":"t","timestamp":"yesterday"`) + `]}`, []string{"embeds.0.timestamp"}},
+		{"Bad embed URL", `{"embeds":[` + embed(`"title":"t","url":"javascript:alert(1)"`) + `]}`, []string{"embeds.0.url"}},
+		{"Bad avatar URL", `{"content":"c","avatar_url":"avatar.png"}`, []string{"avatar_url"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var message webhookMessage
+			if err := json.Unmarshal([]byte(tc.json), &message); err != nil {
+				t.Fatalf("❌ Invalid test payload: %v", err)
+			}
+
+			var fields []string
+			for _, violation := range validateWebhookMessage(message) {
+				fields = append(fields, violation.Field)
+			}
+			if fmt.Sprint(fields) != fmt.Sprint(tc.fields) {
+				t.Errorf("❌ Expected violations of %v, got %v", tc.fields, validateWebhookMessage(message))
+			}
+		})
+	}
+}
+
+// plainPayloadInput is a plain message as the discord service turns it into a payload
+type plainPayloadInput struct {
+	Message    string
+	Title      string
+	SplitLines bool
+}
+
+// plainPayloadInputs are the messages of the PoC tables, the oversized messages of
+// TestDiscordSendEndToEnd and randomized grapheme heavy messages, in both split modes. They are
+// also the seed corpus of FuzzDiscordPayloadLimits
+func plainPayloadInputs() []plainPayloadInput {
+	messages := append([]string{
+		"deploy finished",
+		repeatLines(10, 1000),
+		repeatLines(3, 2500),
+		repeatLines(20, 100),
+		repeatLines(2, 2000) + "\n" + strings.Repeat("x", 1998) + "\n" + strings.Repeat("x", 10),
+		strings.Repeat("word ", 1600),
+		strings.Repeat("x", 10000),
+		strings.Repeat(graphemeFragments[2]+" ", 1200),
+	}, emptyMessageAttacks...)
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		var b strings.Builder
+		for n := rng.Intn(200); n >= 0; n-- {
+			b.WriteString(randomPartitionInput(rng))
+		}
+		messages = append(messages, b.String())
+	}
+
+	var inputs []plainPayloadInput
+	for _, message := range messages {
+		for _, title := range []string{"", "Alert"} {
+			inputs = append(inputs,
+				plainPayloadInput{Message: message, Title: title, SplitLines: true},
+				plainPayloadInput{Message: message, Title: title, SplitLines: false})
+		}
+	}
+	return inputs
+}
+
+// payloadFromPlain follows discord.Send for a plain message and recovers panics from both steps
+func payloadFromPlain(in plainPayloadInput) (result payloadResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			result.Panic = r
+		}
+	}()
+
+	items, omitted := discord.CreateItemsFromPlain(in.Message, in.SplitLines)
+	return createPayloadRecovering(items, in.Title, pocColors, omitted)
+}
+
+// payloadLimitFindings validates a generated payload as it goes over the wire
+func payloadLimitFindings(payload discord.WebhookPayload) (violations []limitViolation, empty bool, err error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, false, err
+	}
+	var message webhookMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, false, err
+	}
+	return validateWebhookMessage(message), isEmptyWebhookMessage(message), nil
+}
+
+// checkGeneratedPayload fails the test when a generated payload would be refused for a limit.
+// Panics are the known finding in vulnerable mode. The omitted footer pushing the embeds over the
+// total and the ellipsis trim of MessageItemsFromLines slicing out of range are not touched by the
+// empty message fix, they are findings in both modes and covered by the other PoC tests
+func checkGeneratedPayload(t *testing.T, mode expectationMode, result payloadResult) (validated bool) {
+	t.Helper()
+
+	switch result.Outcome() {
+	case outcomePanic:
+		switch {
+		case panicClass(fmt.Sprint(result.Panic)) == "slice bounds out of range":
+			t.Logf("🚨 Known finding, trimming a line for the ellipsis slices out of range: %v", result.Panic)
+		case mode == expectFixed:
+			t.Errorf("❌ Unexpected panic in %s mode: %v", mode, result.Panic)
+		}
+		return false
+	case outcomeEmptyError, outcomeOtherError:
+		return false
+	}
+
+	violations, empty, err := payloadLimitFindings(result.Payload)
+	if err != nil {
+		t.Fatalf("❌ Payload does not survive JSON: %v", err)
+	}
+	if empty {
+		t.Logf("⚠️  Payload without visible content, Discord refuses it as an empty message")
+	}
+	footerOverflow := omittedFooterOverflow(result.Payload)
+	for _, violation := range violations {
+		if violation.exceedsEmbedTotal() && (footerOverflow || mode == expectVulnerable) {
+			t.Logf("🚨 Known finding, Discord refuses the payload (%d embeds): %v", len(result.Payload.Embeds), violation)
+			continue
+		}
+		t.Errorf("❌ Discord would refuse the payload (%d embeds): %v", len(result.Payload.Embeds), violation)
+	}
+	return true
+}
+
+// TestGeneratedPayloadsWithinLimits validates everything CreatePayloadFromItems produces from the
+// PoC tables, so no notification is silently dropped because its payload exceeds a Discord limit
+func TestGeneratedPayloadsWithinLimits(t *testing.T) {
+	mode := currentExpectation(t)
+	t.Logf("Expectation mode: %s", mode)
+
+	validated := 0
+	for _, tc := range createPayloadCases {
+		t.Run("table/"+tc.name, func(t *testing.T) {
+			if checkGeneratedPayload(t, mode, createPayloadRecovering(tc.items, tc.title, pocColors, tc.omitted)) {
+				validated++
+			}
+		})
+	}
+
+	for i, in := range plainPayloadInputs() {
+		t.Run(fmt.Sprintf("plain/%d", i), func(t *testing.T) {
+			t.Logf("Message: %q, title %q, splitLines %v", shorten(in.Message, 60), in.Title, in.SplitLines)
+			if checkGeneratedPayload(t, mode, payloadFromPlain(in)) {
+				validated++
+			}
+		})
+	}
+
+	t.Logf("Validated %d generated payloads", validated)
+}
+
+// FuzzDiscordPayloadLimits searches for plain messages that the discord service turns into a
+// payload Discord refuses. Run it with:
+//
+//	go test -run '^$' -fuzz FuzzDiscordPayloadLimits .
+func FuzzDiscordPayloadLimits(f *testing.F) {
+	for _, in := range plainPayloadInputs() {
+		f.Add(in.Message, in.Title, in.SplitLines)
+	}
+
+	f.Fuzz(func(t *testing.T, message string, title string, splitLines bool) {
+		checkGeneratedPayload(t, currentExpectation(t), payloadFromPlain(plainPayloadInput{message, title, splitLines}))
+	})
+}
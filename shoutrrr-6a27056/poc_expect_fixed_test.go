@@ -0,0 +1,7 @@
+//go:build poc_fixed
+// +build poc_fixed
+
+package shoutrrr
+
+// defaultExpectation is used when SHOUTRRR_POC_EXPECT is not set
+const defaultExpectation = expectFixed
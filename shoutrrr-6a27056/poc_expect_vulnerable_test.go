@@ -0,0 +1,8 @@
+//go:build !poc_fixed
+// +build !poc_fixed
+
+package shoutrrr
+
+// defaultExpectation is used when SHOUTRRR_POC_EXPECT is not set. Build with -tags poc_fixed to
+// run the PoC tests as a regression guard instead
+const defaultExpectation = expectVulnerable
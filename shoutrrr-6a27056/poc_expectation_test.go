@@ -0,0 +1,94 @@
+package shoutrrr
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/containrrr/shoutrrr/pkg/services/discord"
+	"github.com/containrrr/shoutrrr/pkg/types"
+)
+
+// expectationMode selects which behaviour the PoC tests assert. In vulnerable mode they pass when
+// the crash reproduces, in fixed mode they act as a regression guard for the ground truth fix
+type expectationMode string
+
+const (
+	expectVulnerable expectationMode = "vulnerable"
+	expectFixed      expectationMode = "fixed"
+)
+
+// expectationEnv overrides the mode selected by the poc_fixed build tag
+const expectationEnv = "SHOUTRRR_POC_EXPECT"
+
+// currentExpectation returns the mode from the environment, falling back to the build tag default
+func currentExpectation(t *testing.T) expectationMode {
+	t.Helper()
+
+	switch mode := expectationMode(os.Getenv(expectationEnv)); mode {
+	case "":
+		return defaultExpectation
+	case expectVulnerable, expectFixed:
+		return mode
+	default:
+		t.Fatalf("❌ Invalid %s=%q, expected %q or %q", expectationEnv, mode, expectVulnerable, expectFixed)
+		return ""
+	}
+}
+
+// payloadOutcome is the observable result of a CreatePayloadFromItems call
+type payloadOutcome int
+
+const (
+	// outcomePayload means a payload was returned without error
+	outcomePayload payloadOutcome = iota
+	// outcomeEmptyError means the "message is empty" error was returned
+	outcomeEmptyError
+	// outcomeOtherError means any other error was returned
+	outcomeOtherError
+	// outcomePanic means the call panicked
+	outcomePanic
+)
+
+func (outcome payloadOutcome) String() string {
+	switch outcome {
+	case outcomePayload:
+		return "payload"
+	case outcomeEmptyError:
+		return "\"message is empty\" error"
+	case outcomeOtherError:
+		return "other error"
+	case outcomePanic:
+		return "panic"
+	}
+	return fmt.Sprintf("payloadOutcome(%d)", int(outcome))
+}
+
+// payloadResult captures everything CreatePayloadFromItems produced, including a recovered panic
+type payloadResult struct {
+	Payload discord.WebhookPayload
+	Err     error
+	Panic   interface{}
+}
+
+func (result payloadResult) Outcome() payloadOutcome {
+	switch {
+	case result.Panic != nil:
+		return outcomePanic
+	case result.Err == nil:
+		return outcomePayload
+	case result.Err.Error() == "message is empty":
+		return outcomeEmptyError
+	}
+	return outcomeOtherError
+}
+
+// createPayloadRecovering calls CreatePayloadFromItems and turns a panic into a result
+func createPayloadRecovering(items []types.MessageItem, title string, colors [types.MessageLevelCount]uint, omitted int) (result payloadResult) {
+	defer func() {
+		result.Panic = recover()
+	}()
+
+	result.Payload, result.Err = discord.CreatePayloadFromItems(items, title, colors, omitted)
+	return result
+}
@@ -0,0 +1,228 @@
+package shoutrrr
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fanOutTarget is one of the URLs a notification is sent to at once
+type fanOutTarget struct {
+	Name      string
+	WebhookID string
+	Token     string
+	Query     string
+	// Err is the status the stand-in answers this URL with, empty when it is delivered
+	Err string
+}
+
+// URL returns the shoutrrr URL of the target
+func (target fanOutTarget) URL() string {
+	serviceURL := fmt.Sprintf("discord://%s@%s", target.Token, target.WebhookID)
+	if target.Query != "" {
+		serviceURL += "?" + target.Query
+	}
+	return serviceURL
+}
+
+// Webhooks of the fan-out targets, the rate limited one has its bucket used up before each send
+var (
+	fanOutAlerts      = fanOutTarget{Name: "alerts", WebhookID: "111111", Token: "alertstoken1"}
+	fanOutDeploys     = fanOutTarget{Name: "deploys", WebhookID: "222222", Token: "deploystoken2"}
+	fanOutRateLimited = fanOutTarget{Name: "rate limited", WebhookID: "333333", Token: "limitedtoken3", Err: "429"}
+	fanOutUnknown     = fanOutTarget{Name: "unknown webhook", WebhookID: "404404", Token: "unknowntoken4", Err: "404"}
+	fanOutWrongToken  = fanOutTarget{Name: "wrong token", WebhookID: "111111", Token: "wrongtoken5", Err: "401"}
+)
+
+// fanOutRuns is how often a scenario is repeated to catch errors that depend on timing
+const fanOutRuns = 20
+
+var statusCodeError = regexp.MustCompile(`response status code (\d{3})`)
+
+// errorStatus returns the status in a discord error, "" for nil and the whole text otherwise
+func errorStatus(err error) string {
+	if err == nil {
+		return ""
+	}
+	if match := statusCodeError.FindStringSubmatch(err.Error()); match != nil {
+		return match[1]
+	}
+	return err.Error()
+}
+
+// fanOutResult is what one ServiceRouter.Send to all targets returned and delivered
+type fanOutResult struct {
+	Errors []error
+	// Delivered counts the accepted requests per webhook ID
+	Delivered map[string]int
+}
+
+// sendFanOut primes the rate limited bucket and sends message to all targets through one router
+func sendFanOut(t *testing.T, standIn *webhookStandIn, targets []fanOutTarget, message string) fanOutResult {
+	t.Helper()
+
+	standIn.SetLimits(rateLimits{BucketSize: 1, BucketReset: time.Minute})
+	if err := Send(fanOutRateLimited.URL(), "primer"); err != nil {
+		t.Fatalf("❌ Could not prime the rate limited bucket: %v", err)
+	}
+
+	urls := make([]string, len(targets))
+	for i, target := range targets {
+		urls[i] = target.URL()
+	}
+	sender, err := NewSender(nil, urls...)
+	if err != nil {
+		t.Fatalf("❌ Could not create the sender: %v", err)
+	}
+
+	result := fanOutResult{Delivered: map[string]int{}}
+	standIn.Reset()
+	result.Errors = sender.Send(message, nil)
+	for _, req := range standIn.Requests() {
+		if req.Error == nil {
+			result.Delivered[req.WebhookID]++
+		}
+	}
+	return result
+}
+
+// misattributed returns the indices whose error does not belong to the URL at that index
+func misattributed(targets []fanOutTarget, errs []error) []int {
+	var indices []int
+	for i, target := range targets {
+		if i >= len(errs) || errorStatus(errs[i]) != target.Err {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// statusMultiset returns the sorted statuses, to compare results regardless of their order
+func statusMultiset(statuses []string) string {
+	sorted := append([]string(nil), statuses...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// TestFanOutPartialFailure sends one notification to several URLs at once, where some targets
+// succeed, some are refused and one crashes on empty content. Every target that can be reached
+// must get the notification and every error must be collected. Whether errs[i] of
+// ServiceRouter.Send is the error of URL i depends on the scheduling, and the empty message fix
+// does not change the router, so a mismatch is a finding in both modes
+func TestFanOutPartialFailure(t *testing.T) {
+	standIn := newWebhookStandIn(t)
+	for _, target := range []fanOutTarget{fanOutAlerts, fanOutDeploys, fanOutRateLimited} {
+		standIn.AddWebhook(target.WebhookID, target.Token)
+	}
+	mode := currentExpectation(t)
+	t.Logf("Expectation mode: %s", mode)
+
+	t.Run("All delivered", func(t *testing.T) {
+		targets := []fanOutTarget{fanOutAlerts, fanOutDeploys}
+		result := sendFanOut(t, standIn, targets, "deploy finished")
+
+		for i, target := range targets {
+			if result.Errors[i] != nil || result.Delivered[target.WebhookID] != 1 {
+				t.Errorf("❌ %s: error %v, delivered %d times", target.Name, result.Errors[i], result.Delivered[target.WebhookID])
+			}
+		}
+	})
+
+	t.Run("Mixed results", func(t *testing.T) {
+		targets := []fanOutTarget{fanOutUnknown, fanOutAlerts, fanOutWrongToken, fanOutDeploys, fanOutRateLimited}
+		var expected []string
+		for _, target := range targets {
+			expected = append(expected, target.Err)
+		}
+
+		wrongRuns := 0
+		for run := 0; run < fanOutRuns; run++ {
+			result := sendFanOut(t, standIn, targets, "deploy finished")
+			if len(result.Errors) != len(targets) {
+				t.Fatalf("❌ Expected one error per URL, got %d for %d URLs", len(result.Errors), len(targets))
+			}
+
+			var actual []string
+			for _, err := range result.Errors {
+				actual = append(actual, errorStatus(err))
+			}
+			if statusMultiset(actual) != statusMultiset(expected) {
+				t.Errorf("❌ Run %d: collected errors %q, expected %q in any order", run, actual, expected)
+			}
+			for _, target := range []fanOutTarget{fanOutAlerts, fanOutDeploys} {
+				if result.Delivered[target.WebhookID] != 1 {
+					t.Errorf("❌ Run %d: %s was delivered %d times next to the failing URLs", run, target.Name, result.Delivered[target.WebhookID])
+				}
+			}
+
+			if indices := misattributed(targets, result.Errors); len(indices) > 0 {
+				wrongRuns++
+				if wrongRuns == 1 {
+					var lines []string
+					for i, target := range targets {
+						lines = append(lines, fmt.Sprintf("  errs[%d] for %-16s expected %-4q got %q", i, target.Name, target.Err, actual[i]))
+					}
+					t.Logf("Run %d:\n%s", run, strings.Join(lines, "\n"))
+				}
+			}
+		}
+
+		if wrongRuns > 0 {
+			t.Logf("🚨 In %d of %d runs errs[i] did not belong to URL i, the errors are collected in completion order", wrongRuns, fanOutRuns)
+			t.Logf("   The errors do not name their service or URL either, so a caller cannot map them back")
+		} else {
+			t.Logf("⚠️  errs[i] belonged to URL i in all %d runs, the sends may just have completed in URL order", fanOutRuns)
+		}
+	})
+
+	t.Run("One invalid URL", func(t *testing.T) {
+		urls := []string{fanOutAlerts.URL(), "discord://" + fanOutDeploys.Token + "@", fanOutDeploys.URL()}
+		standIn.Reset()
+		sender, err := NewSender(nil, urls...)
+		if err == nil {
+			for i, err := range sender.Send("deploy finished", nil) {
+				t.Logf("errs[%d]: %v", i, err)
+			}
+		}
+
+		delivered := 0
+		for _, req := range standIn.Requests() {
+			if req.Error == nil {
+				delivered++
+			}
+		}
+		t.Logf("NewSender error: %v, %d of 2 valid URLs delivered", err, delivered)
+		if delivered != 2 {
+			// Rejecting the whole list is how NewSender is designed, not a defect of the target
+			t.Logf("⚠️  One invalid URL suppresses delivery to the %d valid URLs", 2-delivered)
+		}
+	})
+
+	t.Run("One crash trigger", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("spawns a child process")
+		}
+
+		exploit := subprocessExploits["router-fanout-one-panics"]
+		finding, err := runExploitInChild("router-fanout-one-panics")
+		if err != nil {
+			t.Fatalf("❌ %v", err)
+		}
+		t.Logf("Description: %s", exploit.Description)
+		t.Logf("Finding: %v", finding)
+
+		switch {
+		case finding.Crashed && mode == expectVulnerable:
+			t.Logf("🚨 VULNERABILITY CONFIRMED: the panic of one URL kills the process, no error is returned for the others")
+		case finding.Crashed:
+			t.Errorf("❌ Unexpected crash in %s mode:\n%s", mode, finding.Stderr)
+		case mode == expectVulnerable:
+			t.Errorf("❌ Expected a crash in %s mode but the child exited normally", mode)
+		default:
+			t.Logf("✅ No crash, the router returned")
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package shoutrrr
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containrrr/shoutrrr/pkg/services/discord"
+	"github.com/containrrr/shoutrrr/pkg/types"
+)
+
+// updateGolden rewrites the golden files of the current expectation mode instead of comparing
+var updateGolden = flag.Bool("update", false, "update the golden payload snapshots in testdata")
+
+// goldenPayloadDir holds one directory of snapshots per expectation mode, so the payloads of two
+// target versions can be compared with a plain diff -r
+var goldenPayloadDir = filepath.Join("testdata", "payloads")
+
+// goldenPayloadCase is a CreatePayloadFromItems input that is snapshotted
+type goldenPayloadCase struct {
+	name    string
+	items   []types.MessageItem
+	title   string
+	omitted int
+}
+
+// goldenPayloadCases cover what the length of the embeds does not show: titles, the color and
+// footer of each MessageLevel, timestamps, the omitted footer and the embed order
+func goldenPayloadCases() []goldenPayloadCase {
+	var cases []goldenPayloadCase
+	for _, tc := range createPayloadCases {
+		cases = append(cases, goldenPayloadCase{tc.name, tc.items, tc.title, tc.omitted})
+	}
+
+	var levels []types.MessageItem
+	for level := types.Unknown; int(level) < types.MessageLevelCount; level++ {
+		levels = append(levels, types.MessageItem{Text: "level " + level.String(), Level: level})
+	}
+
+	var ordered []types.MessageItem
+	for i := 1; i <= 12; i++ {
+		ordered = append(ordered, types.MessageItem{Text: fmt.Sprintf("item %d", i)})
+	}
+
+	plain := func(message string, splitLines bool) ([]types.MessageItem, int) {
+		return discord.CreateItemsFromPlain(message, splitLines)
+	}
+	linesItems, linesOmitted := plain("first line\n\nsecond line\nthird line", true)
+	chunkItems, chunkOmitted := plain("first line\n\nsecond line\nthird line", false)
+
+	return append(cases,
+		goldenPayloadCase{"Every message level", levels, "Levels", 0},
+		goldenPayloadCase{"Level outside the colors", []types.MessageItem{{Text: "level 200", Level: 200}}, "", 0},
+		goldenPayloadCase{"Timestamp in another zone", []types.MessageItem{{
+			Text:      "timestamped",
+			Timestamp: time.Date(2021, 3, 14, 15, 9, 26, 535000000, time.FixedZone("CET", 3600)),
+			Level:     types.Info,
+		}}, "", 0},
+		goldenPayloadCase{"Omitted footer without title", []types.MessageItem{{Text: "kept"}}, "", 1234},
+		goldenPayloadCase{"Omitted footer with title", []types.MessageItem{{Text: "kept", Level: types.Warning}}, "Truncated", 1234},
+		goldenPayloadCase{"Twelve items in order", ordered, "Order", 0},
+		goldenPayloadCase{"Plain message with splitLines", linesItems, "Plain", linesOmitted},
+		goldenPayloadCase{"Plain message without splitLines", chunkItems, "Plain", chunkOmitted},
+	)
+}
+
+// goldenPayload is the snapshot of one CreatePayloadFromItems call
+type goldenPayload struct {
+	Outcome string                  `json:"outcome"`
+	Error   string                  `json:"error,omitempty"`
+	Panic   string                  `json:"panic,omitempty"`
+	Payload *discord.WebhookPayload `json:"payload,omitempty"`
+}
+
+// snapshotPayload serializes a result into the indented golden file format
+func snapshotPayload(result payloadResult) ([]byte, error) {
+	snapshot := goldenPayload{Outcome: result.Outcome().String()}
+	switch result.Outcome() {
+	case outcomePanic:
+		snapshot.Panic = fmt.Sprint(result.Panic)
+	case outcomePayload:
+		snapshot.Payload = &result.Payload
+	default:
+		snapshot.Error = result.Err.Error()
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var goldenNameUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// goldenFileName turns a case name into a file name like "every_message_level.json"
+func goldenFileName(name string) string {
+	return strings.Trim(goldenNameUnsafe.ReplaceAllString(strings.ToLower(name), "_"), "_") + ".json"
+}
+
+// lineDiff returns a unified style diff of the lines of want and got, based on their longest
+// common subsequence
+func lineDiff(want, got string) string {
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+shorten(a[i], 120))
+			i++
+		default:
+			lines = append(lines, "+ "+shorten(b[j], 120))
+			j++
+		}
+	}
+
+	// Keep three unchanged lines around each change
+	const context = 3
+	var diff strings.Builder
+	skipped := false
+	for n, line := range lines {
+		near := false
+		for k := n - context; k <= n+context && !near; k++ {
+			near = k >= 0 && k < len(lines) && !strings.HasPrefix(lines[k], "  ")
+		}
+		if !near {
+			skipped = true
+			continue
+		}
+		if skipped {
+			diff.WriteString("  ...\n")
+			skipped = false
+		}
+		diff.WriteString(line + "\n")
+	}
+	return diff.String()
+}
+
+// TestPayloadGoldenSnapshots compares the serialized payload of each case with its golden file
+// in testdata/payloads/<mode>. Run with -update to rewrite the snapshots of the current mode:
+//
+//	go test -run TestPayloadGoldenSnapshots -update .
+func TestPayloadGoldenSnapshots(t *testing.T) {
+	mode := currentExpectation(t)
+	dir := filepath.Join(goldenPayloadDir, string(mode))
+	t.Logf("Expectation mode: %s, snapshots in %s", mode, dir)
+
+	if *updateGolden {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("❌ Could not create %s: %v", dir, err)
+		}
+	}
+
+	for _, tc := range goldenPayloadCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := snapshotPayload(createPayloadRecovering(tc.items, tc.title, pocColors, tc.omitted))
+			if err != nil {
+				t.Fatalf("❌ Could not serialize payload: %v", err)
+			}
+
+			path := filepath.Join(dir, goldenFileName(tc.name))
+			if *updateGolden {
+				if err := os.WriteFile(path, got, 0644); err != nil {
+					t.Fatalf("❌ Could not write %s: %v", path, err)
+				}
+				t.Logf("Updated %s", path)
+				return
+			}
+
+			want, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				t.Fatalf("❌ Missing snapshot %s, run against the %s target with -update to create it", path, mode)
+			} else if err != nil {
+				t.Fatalf("❌ Could not read %s: %v", path, err)
+			}
+
+			if !bytes.Equal(want, got) {
+				t.Errorf("❌ Payload differs from %s:\n%s", path, lineDiff(string(want), string(got)))
+				return
+			}
+			t.Logf("✅ Matches %s", path)
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package shoutrrr
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/containrrr/shoutrrr/pkg/types"
+	"github.com/containrrr/shoutrrr/pkg/util"
+)
+
+// graphemeClass is the Grapheme_Cluster_Break property of a rune (UAX #29), reduced to the
+// classes that matter for deciding whether a chunk boundary splits a user-perceived character
+type graphemeClass int
+
+const (
+	gcOther graphemeClass = iota
+	gcCR
+	gcLF
+	gcControl
+	gcExtend
+	gcZWJ
+	gcRegionalIndicator
+	gcSpacingMark
+	gcL
+	gcV
+	gcT
+	gcLV
+	gcLVT
+	gcExtPict
+)
+
+// classifyGrapheme approximates the Grapheme_Cluster_Break and Extended_Pictographic properties
+// using the standard library unicode tables. It is not a full UAX #29 implementation, but it
+// covers combining marks, emoji ZWJ sequences, skin tone modifiers, flags, CRLF and Hangul
+func classifyGrapheme(r rune) graphemeClass {
+	switch {
+	case r == '\r':
+		return gcCR
+	case r == '\n':
+		return gcLF
+	case r == '\u200D':
+		return gcZWJ
+	case r == '\u200C':
+		return gcExtend
+	case r >= 0x1F3FB && r <= 0x1F3FF: // Emoji skin tone modifiers
+		return gcExtend
+	case r >= 0xE0020 && r <= 0xE007F: // Tag characters used in subdivision flags
+		return gcExtend
+	case r >= 0x1F1E6 && r <= 0x1F1FF:
+		return gcRegionalIndicator
+	case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r):
+		return gcExtend
+	case unicode.Is(unicode.Mc, r):
+		return gcSpacingMark
+	case unicode.Is(unicode.Cc, r) || unicode.Is(unicode.Cf, r) || unicode.Is(unicode.Zl, r) || unicode.Is(unicode.Zp, r):
+		return gcControl
+	case (r >= 0x1100 && r <= 0x115F) || (r >= 0xA960 && r <= 0xA97C):
+		return gcL
+	case (r >= 0x1160 && r <= 0x11A7) || (r >= 0xD7B0 && r <= 0xD7C6):
+		return gcV
+	case (r >= 0x11A8 && r <= 0x11FF) || (r >= 0xD7CB && r <= 0xD7FB):
+		return gcT
+	case r >= 0xAC00 && r <= 0xD7A3:
+		if (r-0xAC00)%28 == 0 {
+			return gcLV
+		}
+		return gcLVT
+	case isExtendedPictographic(r):
+		return gcExtPict
+	}
+	return gcOther
+}
+
+func isExtendedPictographic(r rune) bool {
+	switch r {
+	case 0x00A9, 0x00AE, 0x203C, 0x2049, 0x2122, 0x2139, 0x3030, 0x303D, 0x3297, 0x3299:
+		return true
+	}
+	return (r >= 0x2194 && r <= 0x21AA) ||
+		(r >= 0x2300 && r <= 0x23FF) ||
+		(r >= 0x2600 && r <= 0x27BF) ||
+		(r >= 0x2B00 && r <= 0x2BFF) ||
+		(r >= 0x1F000 && r <= 0x1FAFF && !(r >= 0x1F1E6 && r <= 0x1F1FF)) ||
+		(r >= 0x1FC00 && r <= 0x1FFFD)
+}
+
+// graphemeBoundaries returns, for every rune offset 0..len(runes), whether a grapheme cluster
+// boundary is allowed at that offset
+func graphemeBoundaries(runes []rune) []bool {
+	boundaries := make([]bool, len(runes)+1)
+	if len(runes) == 0 {
+		boundaries[0] = true
+		return boundaries
+	}
+
+	boundaries[0] = true
+	boundaries[len(runes)] = true
+
+	classes := make([]graphemeClass, len(runes))
+	for i, r := range runes {
+		classes[i] = classifyGrapheme(r)
+	}
+
+	// Tracks "ExtPict Extend*" so that a following ZWJ can join the next pictograph (GB11)
+	inPictSequence := classes[0] == gcExtPict
+	// Number of consecutive regional indicators ending at the previous rune (GB12/GB13)
+	riCount := 0
+	if classes[0] == gcRegionalIndicator {
+		riCount = 1
+	}
+
+	for i := 1; i < len(runes); i++ {
+		prev, next := classes[i-1], classes[i]
+		boundaries[i] = graphemeBreakBetween(prev, next, inPictSequence, riCount)
+
+		switch {
+		case next == gcExtPict:
+			inPictSequence = true
+		case next == gcExtend && inPictSequence:
+		case next == gcZWJ && inPictSequence:
+		default:
+			inPictSequence = false
+		}
+		if next == gcZWJ && prev == gcZWJ {
+			inPictSequence = false
+		}
+
+		if next == gcRegionalIndicator {
+			riCount++
+		} else {
+			riCount = 0
+		}
+	}
+
+	return boundaries
+}
+
+func graphemeBreakBetween(prev, next graphemeClass, inPictSequence bool, riCount int) bool {
+	switch {
+	case prev == gcCR && next == gcLF: // GB3
+		return false
+	case prev == gcControl || prev == gcCR || prev == gcLF: // GB4
+		return true
+	case next == gcControl || next == gcCR || next == gcLF: // GB5
+		return true
+	case prev == gcL && (next == gcL || next == gcV || next == gcLV || next == gcLVT): // GB6
+		return false
+	case (prev == gcLV || prev == gcV) && (next == gcV || next == gcT): // GB7
+		return false
+	case (prev == gcLVT || prev == gcT) && next == gcT: // GB8
+		return false
+	case next == gcExtend || next == gcZWJ: // GB9
+		return false
+	case next == gcSpacingMark: // GB9a
+		return false
+	case prev == gcZWJ && next == gcExtPict && inPictSequence: // GB11
+		return false
+	case prev == gcRegionalIndicator && next == gcRegionalIndicator: // GB12, GB13
+		return riCount%2 == 0
+	}
+	return true // GB999
+}
+
+// graphemeViolation describes a MessageItem boundary that lands inside a grapheme cluster
+type graphemeViolation struct {
+	Item       int
+	RuneOffset int
+	ByteOffset int
+	Cluster    string
+}
+
+func (v graphemeViolation) String() string {
+	return fmt.Sprintf("item %d boundary at rune %d (byte %d) splits cluster %q", v.Item, v.RuneOffset, v.ByteOffset, v.Cluster)
+}
+
+// checkGraphemeIntegrity maps the items produced by PartitionMessage back onto input and reports
+// every item boundary that does not coincide with a grapheme cluster boundary. An error is
+// returned if the items cannot be mapped onto the input at all
+func checkGraphemeIntegrity(input string, items []types.MessageItem) ([]graphemeViolation, error) {
+	runes := []rune(input)
+	boundaries := graphemeBoundaries(runes)
+
+	byteOffsets := make([]int, len(runes)+1)
+	for i, r := range runes {
+		byteOffsets[i+1] = byteOffsets[i] + len(string(r))
+	}
+
+	clusterAt := func(offset int) string {
+		start, end := offset, offset
+		for start > 0 && !boundaries[start] {
+			start--
+		}
+		for end < len(runes) && !boundaries[end] {
+			end++
+		}
+		return string(runes[start:end])
+	}
+
+	var violations []graphemeViolation
+	cursor, previousEnd := 0, 0
+	for i, item := range items {
+		itemRunes := []rune(item.Text)
+		if !hasRunePrefix(runes[cursor:], itemRunes) {
+			// PartitionMessage drops the whitespace rune it splits on
+			if cursor < len(runes) && unicode.IsSpace(runes[cursor]) && hasRunePrefix(runes[cursor+1:], itemRunes) {
+				cursor++
+			} else {
+				return violations, fmt.Errorf("item %d (%q) does not continue the input at rune %d", i, item.Text, cursor)
+			}
+		}
+
+		start, end := cursor, cursor+len(itemRunes)
+		offsets := []int{end}
+		if i == 0 || start != previousEnd {
+			offsets = []int{start, end}
+		}
+		for _, offset := range offsets {
+			if offset > 0 && offset < len(runes) && !boundaries[offset] {
+				violations = append(violations, graphemeViolation{
+					Item:       i,
+					RuneOffset: offset,
+					ByteOffset: byteOffsets[offset],
+					Cluster:    clusterAt(offset),
+				})
+			}
+		}
+		cursor = end
+		previousEnd = end
+	}
+
+	return violations, nil
+}
+
+func hasRunePrefix(runes []rune, prefix []rune) bool {
+	if len(prefix) > len(runes) {
+		return false
+	}
+	for i, r := range prefix {
+		if runes[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// graphemeFragments are multi-rune clusters that must never be split across message items
+var graphemeFragments = []string{
+	"e\u0301",       // Base + combining acute accent
+	"a\u0323\u0308", // Base + two combining marks
+	"\U0001F468\u200D\U0001F469\u200D\U0001F467", // Family ZWJ sequence
+	"\U0001F469\U0001F3FD\u200D\U0001F4BB",       // Skin tone modifier + ZWJ sequence
+	"\U0001F3F3\uFE0F\u200D\U0001F308",           // Rainbow flag
+	"\U0001F1F3\U0001F1F4",                       // Regional indicator pair (flag)
+	"\u2764\uFE0F",                               // Emoji presentation selector
+	"\r\n",                                       // CRLF
+	"\u1100\u1161\u11A8",                         // Hangul L V T
+	"\u0915\u094D\u0937\u093F",                   // Devanagari conjunct with spacing mark
+}
+
+// randomPartitionInput builds a message mixing plain words, whitespace and grapheme fragments
+func randomPartitionInput(rng *rand.Rand) string {
+	words := []string{"alert", "disk", "ok", "deploy", "\u00fcn\u00efc\u00f6d\u00e9", "x"}
+	separators := []string{" ", "\n", "", ""}
+
+	var b strings.Builder
+	for n := rng.Intn(40); n >= 0; n-- {
+		if rng.Intn(3) == 0 {
+			b.WriteString(graphemeFragments[rng.Intn(len(graphemeFragments))])
+		} else {
+			b.WriteString(words[rng.Intn(len(words))])
+		}
+		b.WriteString(separators[rng.Intn(len(separators))])
+	}
+	return b.String()
+}
+
+// TestGraphemeIntegrityChecker verifies that the checker flags boundaries inside clusters
+func TestGraphemeIntegrityChecker(t *testing.T) {
+	testCases := []struct {
+		name       string
+		input      string
+		items      []string
+		violations []int
+	}{
+		{"Split on cluster boundary", "ab", []string{"a", "b"}, nil},
+		{"Combining mark split off", "e\u0301x", []string{"e", "\u0301x"}, []int{1}},
+		{"ZWJ sequence split", "\U0001F468\u200D\U0001F469", []string{"\U0001F468\u200D", "\U0001F469"}, []int{2}},
+		{"Flag split", "\U0001F1F3\U0001F1F4", []string{"\U0001F1F3", "\U0001F1F4"}, []int{1}},
+		{"Two flags split between", "\U0001F1F3\U0001F1F4\U0001F1F8\U0001F1EA", []string{"\U0001F1F3\U0001F1F4", "\U0001F1F8\U0001F1EA"}, nil},
+		{"CRLF split", "a\r\nb", []string{"a\r", "\nb"}, []int{2}},
+		{"Whitespace split point skipped", "ab cd", []string{"ab", "cd"}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			items := make([]types.MessageItem, len(tc.items))
+			for i, text := range tc.items {
+				items[i] = types.MessageItem{Text: text}
+			}
+
+			violations, err := checkGraphemeIntegrity(tc.input, items)
+			if err != nil {
+				t.Fatalf("❌ Unexpected mapping error: %v", err)
+			}
+
+			var offsets []int
+			for _, v := range violations {
+				offsets = append(offsets, v.RuneOffset)
+			}
+			if fmt.Sprint(offsets) != fmt.Sprint(tc.violations) {
+				t.Errorf("❌ Expected violations at %v, got %v", tc.violations, violations)
+			}
+		})
+	}
+}
+
+// TestPartitionMessageProperties checks PartitionMessage invariants on randomized input,
+// including that no chunk boundary lands inside a grapheme cluster. Broken glyphs are findings in
+// both modes, the empty message fix does not change how PartitionMessage splits. Panics are
+// findings in vulnerable mode and only fail in fixed mode
+func TestPartitionMessageProperties(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	mode := currentExpectation(t)
+	brokenCases, panicCases := 0, 0
+
+	const iterations = 500
+	for i := 0; i < iterations; i++ {
+		input := randomPartitionInput(rng)
+		chunkSize := 5 + rng.Intn(30)
+		limits := types.MessageLimit{
+			ChunkSize:      chunkSize,
+			TotalChunkSize: chunkSize * (1 + rng.Intn(10)),
+			ChunkCount:     2 + rng.Intn(9),
+		}
+		distance := rng.Intn(chunkSize)
+
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					panicCases++
+					if mode == expectFixed {
+						t.Fatalf("❌ Panic for input %q, limits %+v, distance %d: %v", input, limits, distance, r)
+					}
+					t.Logf("🚨 Panic for input %q, limits %+v, distance %d: %v", input, limits, distance, r)
+				}
+			}()
+
+			items, omitted := util.PartitionMessage(input, limits, distance)
+			runes := []rune(input)
+
+			if len(items) > limits.ChunkCount-1 {
+				t.Errorf("❌ Got %d items, limit allows %d", len(items), limits.ChunkCount-1)
+			}
+
+			consumed := 0
+			for j, item := range items {
+				size := len([]rune(item.Text))
+				if size > limits.ChunkSize {
+					t.Errorf("❌ Item[%d] has %d runes, chunk size is %d", j, size, limits.ChunkSize)
+				}
+				consumed += size
+			}
+			if consumed > limits.TotalChunkSize {
+				t.Errorf("❌ Items hold %d runes, total limit is %d", consumed, limits.TotalChunkSize)
+			}
+			if omitted < 0 || omitted > len(runes) {
+				t.Errorf("❌ Omitted count %d is outside [0, %d]", omitted, len(runes))
+			}
+
+			violations, err := checkGraphemeIntegrity(input, items)
+			if err != nil {
+				t.Fatalf("❌ Items do not map onto input %q: %v", input, err)
+			}
+			if len(violations) == 0 {
+				return
+			}
+			brokenCases++
+			t.Logf("🚨 Broken glyphs for input %q (limits %+v, distance %d):", input, limits, distance)
+			for _, v := range violations {
+				t.Logf("   %v", v)
+			}
+		})
+	}
+
+	t.Logf("%d of %d cases broke a grapheme cluster, %d panicked", brokenCases, iterations, panicCases)
+}
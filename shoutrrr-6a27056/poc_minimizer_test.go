@@ -0,0 +1,539 @@
+package shoutrrr
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"hash/fnv"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containrrr/shoutrrr/pkg/services/discord"
+	"github.com/containrrr/shoutrrr/pkg/types"
+	"github.com/containrrr/shoutrrr/pkg/util"
+)
+
+// crashInput holds every argument the minimizer is allowed to shrink. Targets only read the
+// fields they need, the rest are shrunk to their zero value
+type crashInput struct {
+	Items    []types.MessageItem
+	Title    string
+	Omitted  int
+	Text     string
+	Limits   types.MessageLimit
+	Distance int
+}
+
+// crashTarget is a function under test together with a way to print the same call as Go code
+type crashTarget struct {
+	Name   string
+	Run    func(in crashInput)
+	Source func(in crashInput) string
+}
+
+var pocColors = [types.MessageLevelCount]uint{0xFF0000, 0x00FF00, 0x0000FF, 0xFFFF00, 0xFF00FF}
+
+var createPayloadTarget = crashTarget{
+	Name: "CreatePayloadFromItems",
+	Run: func(in crashInput) {
+		_, _ = discord.CreatePayloadFromItems(in.Items, in.Title, pocColors, in.Omitted)
+	},
+	Source: func(in crashInput) string {
+		return fmt.Sprintf("colors := %s\n"+
+			"discord.CreatePayloadFromItems(%s, %q, colors, %d)\n",
+			colorsSource(pocColors), itemsSource(in.Items), in.Title, in.Omitted)
+	},
+}
+
+var partitionMessageTarget = crashTarget{
+	Name: "PartitionMessage",
+	Run: func(in crashInput) {
+		util.PartitionMessage(in.Text, in.Limits, in.Distance)
+	},
+	Source: func(in crashInput) string {
+		return fmt.Sprintf("util.PartitionMessage(%q, %s, %d)\n", in.Text, limitsSource(in.Limits), in.Distance)
+	},
+}
+
+// partitionThenPayloadTarget follows the path discord.Send takes for plain messages
+var partitionThenPayloadTarget = crashTarget{
+	Name: "PartitionMessage+CreatePayloadFromItems",
+	Run: func(in crashInput) {
+		items, omitted := util.PartitionMessage(in.Text, in.Limits, in.Distance)
+		_, _ = discord.CreatePayloadFromItems(items, in.Title, pocColors, omitted)
+	},
+	Source: func(in crashInput) string {
+		return fmt.Sprintf("items, omitted := util.PartitionMessage(%q, %s, %d)\n"+
+			"colors := %s\n"+
+			"discord.CreatePayloadFromItems(items, %q, colors, omitted)\n",
+			in.Text, limitsSource(in.Limits), in.Distance, colorsSource(pocColors), in.Title)
+	},
+}
+
+func itemsSource(items []types.MessageItem) string {
+	if items == nil {
+		return "nil"
+	}
+	var b strings.Builder
+	b.WriteString("[]types.MessageItem{")
+	for i, item := range items {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fields := []string{fmt.Sprintf("Text: %q", item.Text)}
+		if item.Level != types.Unknown {
+			fields = append(fields, fmt.Sprintf("Level: types.MessageLevel(%d)", item.Level))
+		}
+		if !item.Timestamp.IsZero() {
+			fields = append(fields, fmt.Sprintf("Timestamp: time.Unix(%d, 0)", item.Timestamp.Unix()))
+		}
+		if item.Fields != nil {
+			itemFields := make([]string, len(item.Fields))
+			for j, field := range item.Fields {
+				itemFields[j] = fmt.Sprintf("{Key: %q, Value: %q}", field.Key, field.Value)
+			}
+			fields = append(fields, "Fields: []types.Field{"+strings.Join(itemFields, ", ")+"}")
+		}
+		b.WriteString("{" + strings.Join(fields, ", ") + "}")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func colorsSource(colors [types.MessageLevelCount]uint) string {
+	values := make([]string, len(colors))
+	for i, color := range colors {
+		values[i] = fmt.Sprintf("%#06x", color)
+	}
+	return "[types.MessageLevelCount]uint{" + strings.Join(values, ", ") + "}"
+}
+
+func limitsSource(limits types.MessageLimit) string {
+	return fmt.Sprintf("types.MessageLimit{ChunkSize: %d, TotalChunkSize: %d, ChunkCount: %d}",
+		limits.ChunkSize, limits.TotalChunkSize, limits.ChunkCount)
+}
+
+var panicNumbers = regexp.MustCompile(`-?\d+`)
+
+// panicSignature runs fn and returns an empty string if it returns normally. Otherwise the
+// signature consists of the panic message with numbers masked out and the innermost shoutrrr
+// frame, so that shrinking an index does not count as a different crash
+func panicSignature(fn func()) (signature string) {
+	defer func() {
+		if r := recover(); r != nil {
+			signature = panicNumbers.ReplaceAllString(fmt.Sprint(r), "N") + " at " + faultingFrame(debug.Stack())
+		}
+	}()
+	fn()
+	return ""
+}
+
+// faultingFrame returns "function (file:line)" for the innermost frame of the stack that belongs
+// to the module under test, using the base name of the file
+func faultingFrame(stack []byte) string {
+	frames := targetFrames(parseStackFrames(string(stack)), 1)
+	if len(frames) == 0 {
+		return "unknown frame"
+	}
+	frame := frames[0]
+	return fmt.Sprintf("%s (%s:%d)", frame.Function, filepath.Base(frame.File), frame.Line)
+}
+
+// maxMinimizerRuns bounds the number of target invocations for a single minimization
+const maxMinimizerRuns = 20000
+
+// crashMinimizer shrinks a crashing input while the panic signature stays the same
+type crashMinimizer struct {
+	target    crashTarget
+	signature string
+	runs      int
+}
+
+func (m *crashMinimizer) reproduces(in crashInput) bool {
+	if m.runs >= maxMinimizerRuns {
+		return false
+	}
+	m.runs++
+	return panicSignature(func() { m.target.Run(in) }) == m.signature
+}
+
+// minimizeCrash returns the smallest input found that still reproduces the panic signature of
+// the original input. An error is returned if the original input does not panic
+func minimizeCrash(target crashTarget, in crashInput) (crashInput, string, error) {
+	signature := panicSignature(func() { target.Run(in) })
+	if signature == "" {
+		return in, "", fmt.Errorf("%s does not panic for the supplied input", target.Name)
+	}
+
+	m := &crashMinimizer{target: target, signature: signature}
+	in.Items = cloneItems(in.Items)
+
+	for changed := true; changed; {
+		changed = false
+
+		keep := ddmin(len(in.Items), func(keep []int) bool {
+			candidate := in
+			candidate.Items = selectItems(in.Items, keep)
+			return m.reproduces(candidate)
+		})
+		if len(keep) < len(in.Items) {
+			in.Items = selectItems(in.Items, keep)
+			changed = true
+		}
+
+		for i := range in.Items {
+			changed = m.shrinkString(&in, &in.Items[i].Text) || changed
+			if in.Items[i].Level != types.Unknown {
+				candidate := in
+				candidate.Items = cloneItems(in.Items)
+				candidate.Items[i].Level = types.Unknown
+				if m.reproduces(candidate) {
+					in = candidate
+					changed = true
+				}
+			}
+			if !in.Items[i].Timestamp.IsZero() || in.Items[i].Fields != nil {
+				candidate := in
+				candidate.Items = cloneItems(in.Items)
+				candidate.Items[i].Timestamp = time.Time{}
+				candidate.Items[i].Fields = nil
+				if m.reproduces(candidate) {
+					in = candidate
+					changed = true
+				}
+			}
+		}
+
+		changed = m.shrinkString(&in, &in.Title) || changed
+		changed = m.shrinkString(&in, &in.Text) || changed
+		changed = m.shrinkInt(&in, &in.Omitted) || changed
+		changed = m.shrinkInt(&in, &in.Distance) || changed
+		changed = m.shrinkInt(&in, &in.Limits.ChunkSize) || changed
+		changed = m.shrinkInt(&in, &in.Limits.TotalChunkSize) || changed
+		changed = m.shrinkInt(&in, &in.Limits.ChunkCount) || changed
+
+		if m.runs >= maxMinimizerRuns {
+			break
+		}
+	}
+
+	return in, signature, nil
+}
+
+// shrinkString removes runes from the string field that field points into (a field of in)
+func (m *crashMinimizer) shrinkString(in *crashInput, field *string) bool {
+	runes := []rune(*field)
+	original := *field
+	keep := ddmin(len(runes), func(keep []int) bool {
+		*field = selectRunes(runes, keep)
+		ok := m.reproduces(*in)
+		*field = original
+		return ok
+	})
+	if len(keep) == len(runes) {
+		return false
+	}
+	*field = selectRunes(runes, keep)
+	return true
+}
+
+// shrinkInt moves the int field that field points into (a field of in) towards zero
+func (m *crashMinimizer) shrinkInt(in *crashInput, field *int) bool {
+	changed := false
+	for *field != 0 {
+		current := *field
+		step := 1
+		if current < 0 {
+			step = -1
+		}
+		reduced := false
+		for _, candidate := range []int{0, current / 2, current - step} {
+			if candidate == current {
+				continue
+			}
+			*field = candidate
+			if m.reproduces(*in) {
+				reduced = true
+				break
+			}
+			*field = current
+		}
+		if !reduced {
+			break
+		}
+		changed = true
+	}
+	return changed
+}
+
+// ddmin is Zeller's delta debugging algorithm over the indices 0..n-1. It returns a subset of
+// indices for which reproduces still holds and from which no single chunk can be removed
+func ddmin(n int, reproduces func(keep []int) bool) []int {
+	keep := make([]int, n)
+	for i := range keep {
+		keep[i] = i
+	}
+	if n == 0 {
+		return keep
+	}
+	if reproduces([]int{}) {
+		return []int{}
+	}
+
+	granularity := 2
+	for len(keep) >= 2 {
+		chunk := (len(keep) + granularity - 1) / granularity
+		reduced := false
+		for start := 0; start < len(keep); start += chunk {
+			end := start + chunk
+			if end > len(keep) {
+				end = len(keep)
+			}
+			complement := append(append([]int{}, keep[:start]...), keep[end:]...)
+			if reproduces(complement) {
+				keep = complement
+				if granularity > 2 {
+					granularity--
+				}
+				reduced = true
+				break
+			}
+		}
+		if !reduced {
+			if granularity >= len(keep) {
+				break
+			}
+			granularity *= 2
+			if granularity > len(keep) {
+				granularity = len(keep)
+			}
+		}
+	}
+	return keep
+}
+
+func selectItems(items []types.MessageItem, keep []int) []types.MessageItem {
+	selected := make([]types.MessageItem, 0, len(keep))
+	for _, i := range keep {
+		selected = append(selected, items[i])
+	}
+	return selected
+}
+
+func selectRunes(runes []rune, keep []int) string {
+	selected := make([]rune, 0, len(keep))
+	for _, i := range keep {
+		selected = append(selected, runes[i])
+	}
+	return string(selected)
+}
+
+func cloneItems(items []types.MessageItem) []types.MessageItem {
+	if items == nil {
+		return nil
+	}
+	return append([]types.MessageItem{}, items...)
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// reproductionName returns a test name derived from the target and a hash of the call, so that
+// generated reproductions can be pasted next to each other and next to the existing tests
+func reproductionName(target crashTarget, call string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(call))
+	return fmt.Sprintf("TestReproduce%s_%08x", nonIdentifier.ReplaceAllString(target.Name, ""), hash.Sum32())
+}
+
+// reproductionSource renders a minimized input as a self-contained Go test file. The test fails
+// when the input no longer panics with the message of signature
+func reproductionSource(target crashTarget, in crashInput, signature string) ([]byte, error) {
+	call := target.Source(in)
+	name := reproductionName(target, call)
+	message := signature
+	if at := strings.LastIndex(signature, " at "); at >= 0 {
+		message = signature[:at]
+	}
+
+	imports := []string{`"fmt"`, `"regexp"`, `"testing"`}
+	if strings.Contains(call, "time.") {
+		imports = append(imports, `"time"`)
+	}
+	imports = append(imports, "")
+	if strings.Contains(call, "discord.") {
+		imports = append(imports, `"github.com/containrrr/shoutrrr/pkg/services/discord"`)
+	}
+	imports = append(imports, `"github.com/containrrr/shoutrrr/pkg/types"`)
+	if strings.Contains(call, "util.") {
+		imports = append(imports, `"github.com/containrrr/shoutrrr/pkg/util"`)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("package shoutrrr\n\n")
+	b.WriteString("import (\n" + strings.Join(imports, "\n") + "\n)\n\n")
+	fmt.Fprintf(&b, "// %s reproduces a crash in %s found by the minimizer\n", name, target.Name)
+	fmt.Fprintf(&b, "// Panic signature: %s\n", signature)
+	fmt.Fprintf(&b, "func %s(t *testing.T) {\n", name)
+	fmt.Fprintf(&b, "const expected = %q\n", message)
+	b.WriteString("defer func() {\n")
+	b.WriteString("r := recover()\n")
+	b.WriteString("if r == nil {\n")
+	b.WriteString("t.Fatal(\"❌ No panic, the crash no longer reproduces\")\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "if got := regexp.MustCompile(`%s`).ReplaceAllString(fmt.Sprint(r), \"N\"); got != expected {\n", panicNumbers)
+	b.WriteString("t.Fatalf(\"❌ Expected the panic %q, got %q\", expected, got)\n")
+	b.WriteString("}\n")
+	b.WriteString("t.Logf(\"✅ Crash confirmed: %v\", r)\n")
+	b.WriteString("}()\n\n")
+	b.WriteString(call)
+	b.WriteString("}\n")
+
+	return format.Source(b.Bytes())
+}
+
+// TestDeltaDebuggingMinimizer checks the minimizer against a synthetic crash with a known minimum
+func TestDeltaDebuggingMinimizer(t *testing.T) {
+	target := crashTarget{
+		Name: "synthetic",
+		Run: func(in crashInput) {
+			for _, item := range in.Items {
+				if strings.ContainsRune(item.Text, '\x00') && in.Omitted >= 3 {
+					var embeds []string
+					_ = embeds[len(in.Items)]
+				}
+			}
+		},
+		Source: createPayloadTarget.Source,
+	}
+
+	var items []types.MessageItem
+	for i := 0; i < 200; i++ {
+		items = append(items, types.MessageItem{
+			Text:      strings.Repeat("filler ", i%7),
+			Level:     types.Info,
+			Timestamp: time.Unix(1600000000, 0),
+		})
+	}
+	items[137].Text = "prefix\x00suffix"
+
+	minimal, signature, err := minimizeCrash(target, crashInput{
+		Items:   items,
+		Title:   "A title that does not matter",
+		Omitted: 4000,
+		Text:    strings.Repeat("unused ", 100),
+		Limits:  types.MessageLimit{ChunkSize: 2000, TotalChunkSize: 6000, ChunkCount: 10},
+	})
+	if err != nil {
+		t.Fatalf("❌ %v", err)
+	}
+	t.Logf("Signature: %s", signature)
+
+	expected := crashInput{
+		Items:   []types.MessageItem{{Text: "\x00"}},
+		Omitted: 3,
+	}
+	if fmt.Sprintf("%#v", minimal) != fmt.Sprintf("%#v", expected) {
+		t.Errorf("❌ Expected minimal input %+v, got %+v", expected, minimal)
+	}
+}
+
+// TestReproductionSource checks that a generated reproduction holds the exact input it was
+// minimized from and does not clash with the tests of this package
+func TestReproductionSource(t *testing.T) {
+	in := crashInput{Items: []types.MessageItem{{
+		Text:   "disk full",
+		Fields: []types.Field{{Key: "host", Value: "db1"}},
+	}}}
+	source, err := reproductionSource(createPayloadTarget, in, "runtime error: index out of range [N] with length N at discord.CreatePayloadFromItems (discord_json.go:N)")
+	if err != nil {
+		t.Fatalf("❌ Generated reproduction is not valid Go: %v", err)
+	}
+
+	for _, expected := range []string{
+		`const expected = "runtime error: index out of range [N] with length N"`,
+		`t.Fatal("❌ No panic, the crash no longer reproduces")`,
+		colorsSource(pocColors),
+		`Fields: []types.Field{{Key: "host", Value: "db1"}}`,
+		"func " + reproductionName(createPayloadTarget, createPayloadTarget.Source(in)) + "(t *testing.T)",
+	} {
+		if !bytes.Contains(source, []byte(expected)) {
+			t.Errorf("❌ Expected %q in the reproduction:\n%s", expected, source)
+		}
+	}
+	if bytes.Contains(source, []byte("func TestMinimalReproduction(")) {
+		t.Errorf("❌ The reproduction redeclares TestMinimalReproduction")
+	}
+}
+
+// TestMinimizeCrashingPayload shrinks oversized crashing inputs for the discord payload path and
+// prints the minimized reproduction as a Go test case. In vulnerable mode at least one of them
+// must still crash after minimization
+func TestMinimizeCrashingPayload(t *testing.T) {
+	mode := currentExpectation(t)
+	confirmed := 0
+
+	testCases := []struct {
+		name   string
+		target crashTarget
+		input  crashInput
+	}{
+		{
+			name:   "CreatePayloadFromItems with padded arguments",
+			target: createPayloadTarget,
+			input: crashInput{
+				Items:    []types.MessageItem{},
+				Text:     strings.Repeat("\u200B", 5000),
+				Limits:   types.MessageLimit{ChunkSize: 2000, TotalChunkSize: 6000, ChunkCount: 10},
+				Distance: 100,
+			},
+		},
+		{
+			name:   "PartitionMessage with negative chunk size",
+			target: partitionMessageTarget,
+			input: crashInput{
+				Text:     strings.Repeat("alert: disk almost full\n", 400),
+				Limits:   types.MessageLimit{ChunkSize: -2000, TotalChunkSize: 6000, ChunkCount: 10},
+				Distance: 100,
+			},
+		},
+		{
+			name:   "Partitioned plain message",
+			target: partitionThenPayloadTarget,
+			input: crashInput{
+				Limits:   types.MessageLimit{ChunkSize: 2000, TotalChunkSize: 6000, ChunkCount: 1},
+				Distance: 100,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			minimal, signature, err := minimizeCrash(tc.target, tc.input)
+			if err != nil {
+				t.Skipf("✅ No crash to minimize: %v", err)
+			}
+
+			if minimalSignature := panicSignature(func() { tc.target.Run(minimal) }); minimalSignature != signature {
+				t.Fatalf("❌ The minimized input no longer reproduces %q, got %q", signature, minimalSignature)
+			}
+			confirmed++
+
+			source, err := reproductionSource(tc.target, minimal, signature)
+			if err != nil {
+				t.Fatalf("❌ Generated reproduction is not valid Go: %v", err)
+			}
+
+			t.Logf("🚨 Panic signature: %s", signature)
+			t.Logf("Minimal input: %+v", minimal)
+			t.Logf("Reproduction:\n%s", source)
+		})
+	}
+
+	if mode == expectVulnerable && confirmed == 0 {
+		t.Errorf("❌ Expected at least one minimized input to crash in %s mode", mode)
+	}
+}
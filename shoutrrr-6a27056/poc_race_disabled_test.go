@@ -0,0 +1,8 @@
+//go:build !race
+// +build !race
+
+package shoutrrr
+
+// raceEnabled is whether the test binary was built with -race. Run the stress test with -race to
+// detect data races as well
+const raceEnabled = false
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package shoutrrr
+
+// raceEnabled is whether the test binary was built with -race
+const raceEnabled = true
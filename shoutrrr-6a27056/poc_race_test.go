@@ -0,0 +1,355 @@
+package shoutrrr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containrrr/shoutrrr/pkg/types"
+)
+
+// stressChildEnv makes TestConcurrentSendStressChild run inside the child process
+const stressChildEnv = "SHOUTRRR_POC_STRESS_CHILD"
+
+// stressResultPrefix starts the line the child reports its stressSummary on
+const stressResultPrefix = "STRESS-RESULT "
+
+// Size of the stress run, every worker sends stressMessages messages
+const (
+	stressWorkers  = 16
+	stressMessages = 24
+)
+
+// Second webhook of the stress run, workers alternate between the two
+const (
+	stressWebhookID = "555555"
+	stressToken     = "stresstoken5"
+)
+
+// stressMarker matches the marker every line of a stress message carries, like "w03-m12"
+var stressMarker = regexp.MustCompile(`w\d{2}-m\d{2}`)
+
+// stressPath is a way of sending that shares one sender between all workers
+type stressPath int
+
+const (
+	// stressDefaultRouter calls shoutrrr.Send, which shares the package level router
+	stressDefaultRouter stressPath = iota
+	// stressSharedService calls Send on one discord service instance with a title param
+	stressSharedService
+	// stressSharedRouter calls Send on one ServiceRouter with a title param, only for messages
+	// with content, because a panic in its sender goroutines cannot be recovered
+	stressSharedRouter
+	stressPathCount
+)
+
+func (path stressPath) String() string {
+	return [...]string{"shoutrrr.Send", "shared service", "shared router"}[path]
+}
+
+// stressSend is one message of the stress run
+type stressSend struct {
+	Marker    string
+	WebhookID string
+	Path      stressPath
+	Message   string
+	// Marked is whether the message carries its marker, the edge corpus cannot
+	Marked bool
+	Err    error
+	Panic  interface{}
+}
+
+// stressMessage returns the message a worker sends as its n-th message. The sizes vary from one
+// line to more than the embed total, and every fourth message is from emptyMessageAttacks
+func stressMessage(marker string, n int) (message string, marked bool) {
+	if n%4 == 3 {
+		return emptyMessageAttacks[(n/4)%len(emptyMessageAttacks)], false
+	}
+
+	sizes := []struct{ lines, length int }{{1, 30}, {3, 200}, {8, 700}, {12, 600}}
+	size := sizes[n%len(sizes)]
+	lines := make([]string, size.lines)
+	for i := range lines {
+		line := fmt.Sprintf("%s line %02d ", marker, i)
+		lines[i] = line + strings.Repeat("x", size.length-len(line))
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// stressSummary is what the child process found, reported as JSON to the parent
+type stressSummary struct {
+	Sends    int
+	Errors   int
+	Panics   int
+	Requests int
+	// PanicMessages holds one entry per distinct recovered panic
+	PanicMessages []string
+	// Mixed lists requests that carried markers of more than one message
+	Mixed []string
+	// Misrouted lists messages that arrived at the webhook of another worker
+	Misrouted []string
+	// Duplicated and Missing list markers of messages that arrived more than once, or never
+	// although Send returned nil
+	Duplicated []string
+	Missing    []string
+}
+
+// attributeStressRequests checks that every request carries the markers of exactly one message
+// and that every message arrived where and as often as it should
+func attributeStressRequests(sends []stressSend, requests []webhookRequest) (summary stressSummary) {
+	expected := map[string]stressSend{}
+	for _, send := range sends {
+		if send.Marked {
+			expected[send.Marker] = send
+		}
+	}
+
+	arrived := map[string]int{}
+	for i, req := range requests {
+		if req.Error != nil {
+			continue
+		}
+		markers := map[string]bool{}
+		for _, embed := range req.Message.Embeds {
+			for _, marker := range stressMarker.FindAllString(embed.Title+" "+embed.Content, -1) {
+				markers[marker] = true
+			}
+		}
+
+		var found []string
+		for marker := range markers {
+			found = append(found, marker)
+		}
+		sort.Strings(found)
+		if len(found) > 1 {
+			summary.Mixed = append(summary.Mixed, fmt.Sprintf("request %d: %s", i, strings.Join(found, ", ")))
+		}
+		for _, marker := range found {
+			arrived[marker]++
+			if send, known := expected[marker]; known && send.WebhookID != req.WebhookID {
+				summary.Misrouted = append(summary.Misrouted, fmt.Sprintf("%s sent to %s arrived at %s", marker, send.WebhookID, req.WebhookID))
+			}
+		}
+	}
+
+	for marker, send := range expected {
+		switch {
+		case arrived[marker] > 1:
+			summary.Duplicated = append(summary.Duplicated, marker)
+		case arrived[marker] == 0 && send.Err == nil && send.Panic == nil:
+			summary.Missing = append(summary.Missing, marker)
+		}
+	}
+	sort.Strings(summary.Duplicated)
+	sort.Strings(summary.Missing)
+	return summary
+}
+
+// TestConcurrentSendStressChild runs the stress inside the child process and is skipped otherwise
+func TestConcurrentSendStressChild(t *testing.T) {
+	if os.Getenv(stressChildEnv) == "" {
+		t.Skip("only runs as a child of TestConcurrentSendStress")
+	}
+
+	standIn := newWebhookStandIn(t)
+	standIn.AddWebhook(stressWebhookID, stressToken)
+	webhookURLs := map[string]string{
+		standInWebhookID: standIn.URL(""),
+		stressWebhookID:  fmt.Sprintf("discord://%s@%s", stressToken, stressWebhookID),
+	}
+
+	services := map[string]types.Service{}
+	routers := map[string]interface {
+		Send(string, *types.Params) []error
+	}{}
+	for webhookID, webhookURL := range webhookURLs {
+		sender, err := NewSender(nil, webhookURL)
+		if err != nil {
+			t.Fatalf("could not create the sender: %v", err)
+		}
+		routers[webhookID] = sender
+		if services[webhookID], err = sender.Locate(webhookURL); err != nil {
+			t.Fatalf("could not create the service: %v", err)
+		}
+	}
+
+	sends := make([]stressSend, stressWorkers*stressMessages)
+	var wg sync.WaitGroup
+	for worker := 0; worker < stressWorkers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			webhookID := []string{standInWebhookID, stressWebhookID}[worker%2]
+
+			for n := 0; n < stressMessages; n++ {
+				send := &sends[worker*stressMessages+n]
+				send.Marker = fmt.Sprintf("w%02d-m%02d", worker, n)
+				send.WebhookID = webhookID
+				send.Message, send.Marked = stressMessage(send.Marker, n)
+				send.Path = stressPath((worker + n) % int(stressPathCount))
+				if send.Path == stressSharedRouter && !send.Marked {
+					send.Path = stressSharedService
+				}
+
+				params := &types.Params{"title": send.Marker}
+				func() {
+					defer func() { send.Panic = recover() }()
+					switch send.Path {
+					case stressDefaultRouter:
+						send.Err = Send(webhookURLs[webhookID], send.Message)
+					case stressSharedService:
+						send.Err = services[webhookID].Send(send.Message, params)
+					case stressSharedRouter:
+						send.Err = routers[webhookID].Send(send.Message, params)[0]
+					}
+				}()
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	requests := standIn.Requests()
+	summary := attributeStressRequests(sends, requests)
+	summary.Sends, summary.Requests = len(sends), len(requests)
+	panics := map[string]bool{}
+	for _, send := range sends {
+		switch {
+		case send.Panic != nil:
+			summary.Panics++
+			panics[fmt.Sprintf("%s: %v", send.Path, send.Panic)] = true
+		case send.Err != nil:
+			summary.Errors++
+		}
+	}
+	for message := range panics {
+		summary.PanicMessages = append(summary.PanicMessages, message)
+	}
+	sort.Strings(summary.PanicMessages)
+
+	encoded, err := json.Marshal(summary)
+	if err != nil {
+		t.Fatalf("could not encode the summary: %v", err)
+	}
+	t.Logf("%s%s", stressResultPrefix, encoded)
+}
+
+// dataRace is one "WARNING: DATA RACE" report of the race detector
+type dataRace struct {
+	// Accesses are the first lines of each access, like "Write at 0x... by goroutine 7:" followed
+	// by the innermost function
+	Accesses []string
+	Report   string
+}
+
+// parseDataRaces splits the race detector output in stderr into its reports
+func parseDataRaces(stderr string) []dataRace {
+	var races []dataRace
+	for _, block := range strings.Split(stderr, "==================")[1:] {
+		if !strings.Contains(block, "WARNING: DATA RACE") {
+			continue
+		}
+		race := dataRace{Report: strings.TrimSpace(block)}
+		lines := strings.Split(block, "\n")
+		for i, line := range lines {
+			if (strings.Contains(line, " at 0x") || strings.HasPrefix(line, "Previous ")) && i+1 < len(lines) {
+				race.Accesses = append(race.Accesses, strings.TrimSpace(line)+" "+strings.TrimSpace(lines[i+1]))
+			}
+		}
+		races = append(races, race)
+	}
+	return races
+}
+
+// TestConcurrentSendStress shares senders between many goroutines that send mixed-size messages
+// and the empty/edge corpus to the stand-in, in a child process so that a crash still gives a
+// verdict. It reports data races (when built with -race), panics and requests that carry another
+// message or arrive at another webhook. Run it with:
+//
+//	go test -race -run TestConcurrentSendStress .
+func TestConcurrentSendStress(t *testing.T) {
+	if os.Getenv(stressChildEnv) != "" || os.Getenv(childExploitEnv) != "" {
+		t.Skip("already running as a child process")
+	}
+	if testing.Short() {
+		t.Skip("spawns a child process")
+	}
+	mode := currentExpectation(t)
+	t.Logf("Expectation mode: %s, race detector: %v, %d workers x %d messages", mode, raceEnabled, stressWorkers, stressMessages)
+	if !raceEnabled {
+		t.Logf("⚠️  Built without -race, data races are not detected")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), childTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestConcurrentSendStressChild$", "-test.v")
+	cmd.Env = append(os.Environ(), stressChildEnv+"=1", "GOTRACEBACK=all")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	start := time.Now()
+	runErr := cmd.Run()
+	t.Logf("Child finished after %v: %v", time.Since(start).Round(time.Millisecond), runErr)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		t.Fatalf("❌ The stress run did not finish within %v", childTimeout)
+	}
+	if kind, message := parseCrashHeader(stderr.String()); kind != "" {
+		t.Fatalf("❌ The stress run crashed with %s: %s\n%s", kind, message, stderr.String())
+	}
+
+	races := parseDataRaces(stderr.String() + stdout.String())
+	for i, race := range races {
+		t.Errorf("❌ Data race %d: %s", i+1, strings.Join(race.Accesses, " / "))
+		t.Logf("%s", race.Report)
+	}
+
+	var summary stressSummary
+	index := strings.Index(stdout.String(), stressResultPrefix)
+	if index < 0 {
+		t.Fatalf("❌ The child reported no result:\n%s\n%s", stdout.String(), stderr.String())
+	}
+	line := stdout.String()[index+len(stressResultPrefix):]
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	if err := json.Unmarshal([]byte(line), &summary); err != nil {
+		t.Fatalf("❌ Invalid result %q: %v", line, err)
+	}
+
+	t.Logf("%d sends, %d requests, %d errors, %d recovered panics", summary.Sends, summary.Requests, summary.Errors, summary.Panics)
+	for _, message := range summary.PanicMessages {
+		if mode == expectVulnerable {
+			t.Logf("🚨 Recovered panic: %s", message)
+		} else {
+			t.Errorf("❌ Unexpected panic in %s mode: %s", mode, message)
+		}
+	}
+	if mode == expectVulnerable && summary.Panics == 0 {
+		t.Errorf("❌ Expected the empty messages to panic in %s mode", mode)
+	}
+
+	for _, mixed := range summary.Mixed {
+		t.Errorf("❌ Payloads of several messages in one %s", mixed)
+	}
+	for _, misrouted := range summary.Misrouted {
+		t.Errorf("❌ Misrouted: %s", misrouted)
+	}
+	if len(summary.Duplicated) > 0 {
+		t.Errorf("❌ %d messages arrived more than once: %v", len(summary.Duplicated), summary.Duplicated)
+	}
+	if len(summary.Missing) > 0 {
+		t.Errorf("❌ %d messages never arrived although Send returned nil: %v", len(summary.Missing), summary.Missing)
+	}
+
+	t.Logf("Stress result: %d data races, %d panics, %d mixed requests, %d misrouted, %d duplicated, %d missing",
+		len(races), summary.Panics, len(summary.Mixed), len(summary.Misrouted), len(summary.Duplicated), len(summary.Missing))
+}
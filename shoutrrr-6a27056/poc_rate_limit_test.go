@@ -0,0 +1,392 @@
+package shoutrrr
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rateLimits configures the 429 and 5xx responses of the webhook stand-in
+type rateLimits struct {
+	// BucketSize requests per webhook are accepted every BucketReset, 0 disables the bucket
+	BucketSize  int
+	BucketReset time.Duration
+	// GlobalSize requests over all webhooks are accepted every GlobalReset, 0 disables the limit
+	GlobalSize  int
+	GlobalReset time.Duration
+	// ServerErrors answers the first requests with these statuses in order, 0 lets one through
+	ServerErrors []int
+}
+
+// rateBucket counts the requests left until reset
+type rateBucket struct {
+	remaining int
+	reset     time.Time
+}
+
+// take uses one request of the bucket, refilling it when the reset has passed
+func (bucket *rateBucket) take(now time.Time, size int, reset time.Duration) bool {
+	if !now.Before(bucket.reset) {
+		bucket.remaining = size
+		bucket.reset = now.Add(reset)
+	}
+	if bucket.remaining == 0 {
+		return false
+	}
+	bucket.remaining--
+	return true
+}
+
+// rateLimiter applies rateLimits the way the Discord API reports them, with Retry-After and
+// X-RateLimit-* headers and a retry_after field in the 429 body
+type rateLimiter struct {
+	limits rateLimits
+
+	mu      sync.Mutex
+	served  int
+	global  rateBucket
+	buckets map[string]*rateBucket
+}
+
+func newRateLimiter(limits rateLimits) *rateLimiter {
+	return &rateLimiter{limits: limits, buckets: map[string]*rateBucket{}}
+}
+
+// take decides whether a request to webhookID received at now is accepted and sets the rate
+// limit headers of the response. A nil limiter accepts everything
+func (limiter *rateLimiter) take(webhookID string, header http.Header, now time.Time) (int, *discordAPIError) {
+	if limiter == nil {
+		return 0, nil
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	served := limiter.served
+	limiter.served++
+	if served < len(limiter.limits.ServerErrors) && limiter.limits.ServerErrors[served] != 0 {
+		status := limiter.limits.ServerErrors[served]
+		return status, &discordAPIError{Message: fmt.Sprintf("%d: %s", status, http.StatusText(status))}
+	}
+
+	if limiter.limits.GlobalSize > 0 && !limiter.global.take(now, limiter.limits.GlobalSize, limiter.limits.GlobalReset) {
+		retryAfter := limiter.global.reset.Sub(now)
+		header.Set("Retry-After", retryAfterSeconds(retryAfter))
+		header.Set("X-RateLimit-Global", "true")
+		header.Set("X-RateLimit-Scope", "global")
+		return http.StatusTooManyRequests, &discordAPIError{Message: "You are being rate limited.", RetryAfter: retryAfter.Seconds(), Global: true}
+	}
+
+	if limiter.limits.BucketSize > 0 {
+		bucket, found := limiter.buckets[webhookID]
+		if !found {
+			bucket = &rateBucket{}
+			limiter.buckets[webhookID] = bucket
+		}
+		accepted := bucket.take(now, limiter.limits.BucketSize, limiter.limits.BucketReset)
+
+		resetAfter := bucket.reset.Sub(now)
+		header.Set("X-RateLimit-Bucket", "webhook-"+webhookID)
+		header.Set("X-RateLimit-Limit", strconv.Itoa(limiter.limits.BucketSize))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(bucket.remaining))
+		header.Set("X-RateLimit-Reset", strconv.FormatFloat(float64(bucket.reset.UnixNano())/1e9, 'f', 3, 64))
+		header.Set("X-RateLimit-Reset-After", strconv.FormatFloat(resetAfter.Seconds(), 'f', 3, 64))
+
+		if !accepted {
+			header.Set("Retry-After", retryAfterSeconds(resetAfter))
+			header.Set("X-RateLimit-Scope", "user")
+			return http.StatusTooManyRequests, &discordAPIError{Message: "You are being rate limited.", RetryAfter: resetAfter.Seconds()}
+		}
+	}
+
+	return 0, nil
+}
+
+// retryAfterSeconds formats the Retry-After header, which Discord rounds up to whole seconds
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// TestRateLimiterResponses checks the 429 and 5xx responses of the stand-in on raw requests
+func TestRateLimiterResponses(t *testing.T) {
+	standIn := newWebhookStandIn(t)
+	standIn.AddWebhook(otherWebhookID, otherToken)
+	endpoint := func(webhookID, token string) string {
+		return fmt.Sprintf("https://discord.com/api/webhooks/%s/%s", webhookID, token)
+	}
+	post := func(t *testing.T, postURL string) *http.Response {
+		t.Helper()
+		res, err := http.Post(postURL, "application/json", strings.NewReader(`{"content":"rate limit"}`))
+		if err != nil {
+			t.Fatalf("❌ Request failed: %v", err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	t.Run("Webhook bucket", func(t *testing.T) {
+		standIn.SetLimits(rateLimits{BucketSize: 2, BucketReset: time.Second})
+		statuses := []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests}
+		remaining := []string{"1", "0", "0"}
+		for i, expected := range statuses {
+			res := post(t, endpoint(standInWebhookID, standInToken))
+			if res.StatusCode != expected {
+				t.Errorf("❌ Request %d: expected %d, got %d", i, expected, res.StatusCode)
+			}
+			if got := res.Header.Get("X-RateLimit-Remaining"); got != remaining[i] {
+				t.Errorf("❌ Request %d: expected X-RateLimit-Remaining %s, got %q", i, remaining[i], got)
+			}
+		}
+
+		requests := standIn.Requests()
+		limited := requests[len(requests)-1]
+		if limited.Header.Get("Retry-After") != "1" || limited.Error.RetryAfter <= 0 || limited.Error.Global {
+			t.Errorf("❌ Expected a webhook scoped 429 with Retry-After 1, got %q and %+v", limited.Header.Get("Retry-After"), limited.Error)
+		}
+		if res := post(t, endpoint(otherWebhookID, otherToken)); res.StatusCode != http.StatusNoContent {
+			t.Errorf("❌ Buckets are per webhook, the other webhook got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("Global limit", func(t *testing.T) {
+		standIn.SetLimits(rateLimits{GlobalSize: 1, GlobalReset: time.Second})
+		post(t, endpoint(otherWebhookID, otherToken))
+		res := post(t, endpoint(standInWebhookID, standInToken))
+		if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("X-RateLimit-Global") != "true" {
+			t.Errorf("❌ Expected a global 429, got %d with X-RateLimit-Global %q", res.StatusCode, res.Header.Get("X-RateLimit-Global"))
+		}
+	})
+
+	t.Run("Server errors", func(t *testing.T) {
+		standIn.SetLimits(rateLimits{ServerErrors: []int{http.StatusBadGateway, 0, http.StatusServiceUnavailable}})
+		statuses := []int{http.StatusBadGateway, http.StatusNoContent, http.StatusServiceUnavailable, http.StatusNoContent}
+		for i, expected := range statuses {
+			if res := post(t, endpoint(standInWebhookID, standInToken)); res.StatusCode != expected {
+				t.Errorf("❌ Request %d: expected %d, got %d", i, expected, res.StatusCode)
+			}
+		}
+	})
+}
+
+// chunkedMessage returns count lines of length runes that start with a label like "chunk 01"
+// and the labels, so the lines can be found in the embeds that were delivered
+func chunkedMessage(count int, length int) (message string, labels []string) {
+	lines := make([]string, count)
+	for i := range lines {
+		label := fmt.Sprintf("chunk %02d", i+1)
+		labels = append(labels, label)
+		lines[i] = label + " " + strings.Repeat("x", length-len(label)-1)
+	}
+	return strings.Join(lines, "\n"), labels
+}
+
+// retryReport is what the discord sender did with the 429 and 5xx responses of one Send
+type retryReport struct {
+	Requests     int
+	RateLimited  int
+	ServerErrors int
+	// Retries counts requests sent after a 429 or 5xx response
+	Retries int
+	// EarlyRetries counts retries sent before retry_after had passed
+	EarlyRetries int
+	Delivered    []string
+	Lost         []string
+}
+
+// analyseRetries follows the requests of a Send in the order they were received
+func analyseRetries(result sendResult, labels []string) retryReport {
+	report := retryReport{Requests: len(result.Requests)}
+
+	for i, req := range result.Requests {
+		switch {
+		case req.Status == http.StatusTooManyRequests:
+			report.RateLimited++
+		case req.Status >= 500:
+			report.ServerErrors++
+		}
+
+		if i == 0 {
+			continue
+		}
+		previous := result.Requests[i-1]
+		if previous.Status == http.StatusTooManyRequests || previous.Status >= 500 {
+			report.Retries++
+		}
+		if previous.Status == http.StatusTooManyRequests {
+			wait := time.Duration(previous.Error.RetryAfter * float64(time.Second))
+			if req.Received.Sub(previous.Received) < wait {
+				report.EarlyRetries++
+			}
+		}
+	}
+
+	var accepted []webhookRequest
+	for _, req := range result.Requests {
+		if req.Error == nil {
+			accepted = append(accepted, req)
+		}
+	}
+	for _, label := range labels {
+		if requestsContain(accepted, label) {
+			report.Delivered = append(report.Delivered, label)
+		} else {
+			report.Lost = append(report.Lost, label)
+		}
+	}
+
+	return report
+}
+
+// Behaviour sums up how the sender handled the responses
+func (report retryReport) Behaviour(err error) string {
+	retried := ""
+	if report.Retries > 0 {
+		retried = fmt.Sprintf("retried %d times, ", report.Retries)
+	}
+
+	switch {
+	case len(report.Lost) == 0 && err == nil:
+		return retried + "delivered"
+	case err == nil:
+		return retried + "dropped silently"
+	case len(report.Delivered) > 0:
+		return retried + "partially delivered, returned an error"
+	}
+	return retried + "returned an error"
+}
+
+// rateLimitScenario primes the stand-in and sends a chunked message while limits apply
+type rateLimitScenario struct {
+	name   string
+	limits rateLimits
+	// primeWebhook is sent one message before the test message to use up a bucket
+	primeWebhook string
+	description  string
+}
+
+// Credentials of the second webhook used to exhaust the global limit
+const (
+	otherWebhookID = "987654321"
+	otherToken     = "fed654cba321"
+)
+
+// TestRateLimitHandling sends multi-embed messages while the stand-in answers with 429 and 5xx
+// responses and reports whether the discord sender retries, honours Retry-After, drops chunks or
+// returns an error. The sender may retry or give up, but it must never lose chunks without an
+// error and never retry before retry_after has passed
+func TestRateLimitHandling(t *testing.T) {
+	standIn := newWebhookStandIn(t)
+	standIn.AddWebhook(otherWebhookID, otherToken)
+
+	scenarios := []rateLimitScenario{
+		{
+			name:        "No limits",
+			description: "Control case",
+		},
+		{
+			name:         "Webhook bucket exhausted",
+			limits:       rateLimits{BucketSize: 1, BucketReset: time.Second},
+			primeWebhook: standInWebhookID,
+			description:  "The previous message used the only request of the webhook bucket",
+		},
+		{
+			name:        "Webhook bucket of one request",
+			limits:      rateLimits{BucketSize: 1, BucketReset: time.Second},
+			description: "The first request passes, every further request in the same second gets a 429",
+		},
+		{
+			name:         "Global limit exhausted",
+			limits:       rateLimits{GlobalSize: 1, GlobalReset: time.Second},
+			primeWebhook: otherWebhookID,
+			description:  "A message to another webhook used up the global limit",
+		},
+		{
+			name:        "Single 500",
+			limits:      rateLimits{ServerErrors: []int{http.StatusInternalServerError}},
+			description: "One transient server error",
+		},
+		{
+			name:        "5xx burst",
+			limits:      rateLimits{ServerErrors: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}},
+			description: "Cloudflare errors for the first three requests",
+		},
+		{
+			name:        "5xx after the first request",
+			limits:      rateLimits{ServerErrors: []int{0, http.StatusServiceUnavailable}},
+			description: "Only matters when a message is split across requests",
+		},
+	}
+
+	multiEmbed, multiEmbedLabels := chunkedMessage(8, 600)
+	multiRequest, multiRequestLabels := chunkedMessage(16, 700)
+	messages := []struct {
+		name    string
+		message string
+		labels  []string
+	}{
+		{"8 embeds", multiEmbed, multiEmbedLabels},
+		{"16 embeds over the total", multiRequest, multiRequestLabels},
+	}
+
+	var report strings.Builder
+	for _, scenario := range scenarios {
+		scenario := scenario
+		t.Run(scenario.name, func(t *testing.T) {
+			t.Logf("Description: %s", scenario.description)
+
+			for _, msg := range messages {
+				standIn.SetLimits(scenario.limits)
+				if scenario.primeWebhook != "" {
+					token := map[string]string{standInWebhookID: standInToken, otherWebhookID: otherToken}[scenario.primeWebhook]
+					if err := Send(fmt.Sprintf("discord://%s@%s", token, scenario.primeWebhook), "primer"); err != nil {
+						t.Fatalf("❌ Could not prime the bucket: %v", err)
+					}
+				}
+
+				result := sendRecovering(standIn, func() error { return Send(standIn.URL(""), msg.message) })
+				if result.Panic != nil {
+					t.Errorf("❌ %s: unexpected panic\n%s", msg.name, result.CrashReport)
+					continue
+				}
+
+				retries := analyseRetries(result, msg.labels)
+				behaviour := retries.Behaviour(result.Err)
+				lastStatus := 0
+				if len(result.Requests) > 0 {
+					lastStatus = result.Requests[len(result.Requests)-1].Status
+				}
+				fmt.Fprintf(&report, "%-30s %-26s %-48s %3d  %d/%d chunks\n",
+					scenario.name, msg.name, behaviour, lastStatus, len(retries.Delivered), len(msg.labels))
+
+				t.Logf("%s: %s", msg.name, behaviour)
+				for i, req := range result.Requests {
+					t.Logf("  Request[%d]: %d, %d embeds, Retry-After %q, API error: %v",
+						i, req.Status, len(req.Message.Embeds), req.Header.Get("Retry-After"), req.Error)
+				}
+				if result.Err != nil {
+					t.Logf("  Error: %v", result.Err)
+				}
+
+				if result.Err == nil && len(retries.Lost) > 0 {
+					t.Errorf("❌ %s: %d chunks were lost without an error: %v", msg.name, len(retries.Lost), retries.Lost)
+				}
+				if retries.EarlyRetries > 0 {
+					t.Errorf("❌ %s: %d retries were sent before Retry-After had passed", msg.name, retries.EarlyRetries)
+				}
+				if retries.Retries > 5 {
+					t.Errorf("❌ %s: %d retries for one message hammer the API", msg.name, retries.Retries)
+				}
+				if result.Err != nil && retries.RateLimited+retries.ServerErrors > 0 && retries.Retries == 0 {
+					t.Logf("⚠️  %s: gave up on the first %d response, the notification is lost", msg.name, result.Requests[len(result.Requests)-1].Status)
+				}
+			}
+		})
+	}
+
+	t.Logf("Rate limit handling (scenario, message, behaviour, last status, delivered chunks):\n%s", report.String())
+}
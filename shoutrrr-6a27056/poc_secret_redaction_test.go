@@ -0,0 +1,232 @@
+package shoutrrr
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redactionOutput is everything an error path produced that could end up in a log
+type redactionOutput struct {
+	Errors []error
+	Log    string
+	// CrashReport is set when the path panicked, a recovering caller would log it
+	CrashReport string
+}
+
+// secretLeak is one place a secret was found in
+type secretLeak struct {
+	Location string
+	// Context is the text around the secret, with the secret replaced
+	Context string
+}
+
+// findSecret returns every location in output that contains secret
+func findSecret(output redactionOutput, secret string) []secretLeak {
+	locations := map[string]string{"log": output.Log, "crash report": output.CrashReport}
+	names := []string{"log", "crash report"}
+	for i, err := range output.Errors {
+		if err != nil {
+			name := fmt.Sprintf("error[%d]", i)
+			locations[name] = err.Error()
+			names = append([]string{name}, names...)
+		}
+	}
+
+	var leaks []secretLeak
+	for _, name := range names {
+		text := locations[name]
+		for offset := 0; ; {
+			index := strings.Index(text[offset:], secret)
+			if index < 0 {
+				break
+			}
+			index += offset
+			start, end := index-60, index+len(secret)+20
+			if start < 0 {
+				start = 0
+			}
+			if end > len(text) {
+				end = len(text)
+			}
+			context := strings.ReplaceAll(text[start:end], secret, "<TOKEN>")
+			leaks = append(leaks, secretLeak{Location: name, Context: strings.ReplaceAll(context, "\n", " ")})
+			offset = index + len(secret)
+		}
+	}
+	return leaks
+}
+
+// redactionEnv is what the error paths run against
+type redactionEnv struct {
+	standIn *webhookStandIn
+	log     *lockedBuffer
+	logger  *log.Logger
+}
+
+// send calls shoutrrr.Send with the default router logging to the buffer and recovers panics
+func (env *redactionEnv) send(rawURL string, message string) redactionOutput {
+	result := sendRecovering(env.standIn, func() error { return Send(rawURL, message) })
+	return redactionOutput{Errors: []error{result.Err}, CrashReport: result.CrashReport}
+}
+
+// redactionCase forces one error path
+type redactionCase struct {
+	name string
+	kind string
+	// secret is the token that must not appear, standInToken when empty
+	secret string
+	run    func(t *testing.T, env *redactionEnv) redactionOutput
+}
+
+// sendTo returns a case run that sends message to rawURL
+func sendTo(rawURL string, message string) func(t *testing.T, env *redactionEnv) redactionOutput {
+	return func(t *testing.T, env *redactionEnv) redactionOutput {
+		return env.send(rawURL, message)
+	}
+}
+
+// wrongToken is used to get a 401 from the stand-in
+const wrongToken = "wr0ngt0ken789"
+
+// redactionCases cover every way a discord notification can fail
+func redactionCases(standIn *webhookStandIn) []redactionCase {
+	webhookURL := standIn.URL("")
+
+	return []redactionCase{
+		{name: "Unparsable URL", kind: "bad URL", run: sendTo(webhookURL+"/%zz", "deploy finished")},
+		{name: "Missing webhook ID", kind: "bad URL", run: sendTo("discord://"+standInToken+"@", "deploy finished")},
+		{name: "Invalid color", kind: "bad URL", run: sendTo(standIn.URL("color=zz"), "deploy finished")},
+		{name: "Unknown config key", kind: "bad URL", run: sendTo(standIn.URL("foo=bar"), "deploy finished")},
+		{name: "Misspelled scheme", kind: "bad URL", run: sendTo(strings.Replace(webhookURL, "discord://", "discrd://", 1), "deploy finished")},
+		{
+			name: "Custom webhook URL",
+			kind: "bad URL",
+			run:  sendTo(fmt.Sprintf("discord+https://discord.com/api/webhooks/%s/%s", standInWebhookID, standInToken), "deploy finished"),
+		},
+		{
+			name:   "Wrong token",
+			kind:   "4xx",
+			secret: wrongToken,
+			run:    sendTo(fmt.Sprintf("discord://%s@%s", wrongToken, standInWebhookID), "deploy finished"),
+		},
+		{name: "Whitespace message", kind: "4xx", run: sendTo(webhookURL, " ")},
+		{name: "Oversized message", kind: "4xx", run: sendTo(webhookURL, repeatLines(10, 1000))},
+		{
+			name: "Rate limited",
+			kind: "4xx",
+			run: func(t *testing.T, env *redactionEnv) redactionOutput {
+				env.standIn.SetLimits(rateLimits{BucketSize: 1, BucketReset: time.Minute})
+				defer env.standIn.SetLimits(rateLimits{})
+				if err := Send(webhookURL, "primer"); err != nil {
+					t.Fatalf("❌ Could not prime the bucket: %v", err)
+				}
+				return env.send(webhookURL, "deploy finished")
+			},
+		},
+		{
+			name: "Bad gateway",
+			kind: "5xx",
+			run: func(t *testing.T, env *redactionEnv) redactionOutput {
+				env.standIn.SetLimits(rateLimits{ServerErrors: []int{http.StatusBadGateway}})
+				defer env.standIn.SetLimits(rateLimits{})
+				return env.send(webhookURL, "deploy finished")
+			},
+		},
+		{
+			name: "Connection closed without response",
+			kind: "timeout",
+			run: func(t *testing.T, env *redactionEnv) redactionOutput {
+				env.standIn.SetSlowdown(slowResponse{Hang: true})
+				defer env.standIn.SetSlowdown(slowResponse{})
+				time.AfterFunc(200*time.Millisecond, env.standIn.Release)
+				return env.send(webhookURL, "deploy finished")
+			},
+		},
+		{
+			name: "Router timeout",
+			kind: "timeout",
+			run: func(t *testing.T, env *redactionEnv) redactionOutput {
+				sender, err := NewSender(env.logger, webhookURL)
+				if err != nil {
+					t.Fatalf("❌ Could not create the sender: %v", err)
+				}
+				sender.Timeout = 200 * time.Millisecond
+
+				env.standIn.SetSlowdown(slowResponse{Hang: true})
+				defer env.standIn.SetSlowdown(slowResponse{})
+				defer env.standIn.Release()
+				return redactionOutput{Errors: sender.Send("deploy finished", nil)}
+			},
+		},
+		{
+			name: "Connection refused",
+			kind: "timeout",
+			run: func(t *testing.T, env *redactionEnv) redactionOutput {
+				closed := httptest.NewServer(http.NotFoundHandler())
+				closed.Close()
+				routeToStandIn(t, closed, func(host string) bool { return discordHosts[host] })
+				return env.send(webhookURL, "deploy finished")
+			},
+		},
+		{name: "Invalid JSON in json mode", kind: "payload", run: sendTo(standIn.URL("json=yes"), "{not json")},
+		{name: "Empty message", kind: "payload", run: sendTo(webhookURL, "")},
+	}
+}
+
+// TestSecretRedaction forces every error path of the discord service and scans the returned
+// errors, the logger output and crash reports for the webhook token. Anyone who can read the
+// logs of a service using shoutrrr can post to the webhook with a leaked token. The leak table
+// is the result in both modes, the empty message fix does not touch any of these paths
+func TestSecretRedaction(t *testing.T) {
+	standIn := newWebhookStandIn(t)
+
+	env := &redactionEnv{standIn: standIn, log: &lockedBuffer{}}
+	env.logger = log.New(env.log, "", 0)
+	SetLogger(env.logger)
+	t.Cleanup(func() { SetLogger(nil) })
+
+	var table strings.Builder
+	leaking := 0
+	for _, tc := range redactionCases(standIn) {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			env.log.Reset()
+			secret := tc.secret
+			if secret == "" {
+				secret = standInToken
+			}
+
+			output := tc.run(t, env)
+			output.Log = strings.Join(env.log.Lines(), "")
+
+			failed := output.CrashReport != ""
+			for _, err := range output.Errors {
+				failed = failed || err != nil
+			}
+			if !failed {
+				t.Fatalf("❌ The %s error path was not taken, Send succeeded", tc.kind)
+			}
+
+			leaks := findSecret(output, secret)
+			var locations []string
+			for _, leak := range leaks {
+				locations = append(locations, leak.Location)
+				t.Logf("🚨 Token leaks in %s: %s", leak.Location, leak.Context)
+			}
+			if len(leaks) == 0 {
+				t.Logf("✅ No token in %d errors, %d bytes of log output", len(output.Errors), len(output.Log))
+			} else {
+				leaking++
+			}
+			fmt.Fprintf(&table, "%-36s %-8s %s\n", tc.name, tc.kind, strings.Join(locations, ", "))
+		})
+	}
+
+	t.Logf("Token leaks per error path (case, kind, locations):\n%s", table.String())
+	t.Logf("%d error paths leak the webhook token", leaking)
+}
@@ -0,0 +1,254 @@
+package shoutrrr
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/containrrr/shoutrrr/pkg/services/discord"
+	"github.com/containrrr/shoutrrr/pkg/types"
+)
+
+// targetPackagePrefix matches the functions of the module under test, but not this PoC package
+const targetPackagePrefix = "github.com/containrrr/shoutrrr/pkg/"
+
+// excerptContext is the number of source lines shown before and after the faulting line
+const excerptContext = 3
+
+// stackFrame is a single resolved frame of a Go traceback
+type stackFrame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+func (frame stackFrame) String() string {
+	return fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line)
+}
+
+// parseStackFrames parses one goroutine block of a traceback, as printed by the runtime on a
+// crash or returned by debug.Stack, into frames ordered from the innermost call outwards
+func parseStackFrames(goroutine string) []stackFrame {
+	var frames []stackFrame
+	lines := strings.Split(goroutine, "\n")
+
+	for i := 0; i+1 < len(lines); i++ {
+		function := lines[i]
+		location := lines[i+1]
+		if function == "" || strings.HasPrefix(function, "\t") || !strings.HasPrefix(location, "\t") {
+			continue
+		}
+
+		if strings.HasPrefix(function, "created by ") {
+			function = strings.TrimPrefix(function, "created by ")
+			if in := strings.Index(function, " in goroutine "); in > 0 {
+				function = function[:in]
+			}
+		} else if strings.HasSuffix(function, ")") {
+			if paren := strings.LastIndex(function, "("); paren > 0 {
+				function = function[:paren]
+			}
+		}
+
+		location = strings.TrimSpace(location)
+		if offset := strings.LastIndex(location, " +0x"); offset > 0 {
+			location = location[:offset]
+		}
+		colon := strings.LastIndex(location, ":")
+		if colon < 0 {
+			continue
+		}
+		line, err := strconv.Atoi(location[colon+1:])
+		if err != nil {
+			continue
+		}
+
+		frames = append(frames, stackFrame{
+			Function: function,
+			File:     location[:colon],
+			Line:     line,
+		})
+		i++
+	}
+
+	return frames
+}
+
+// targetFrames returns up to limit frames that belong to the module under test
+func targetFrames(frames []stackFrame, limit int) []stackFrame {
+	var matching []stackFrame
+	for _, frame := range frames {
+		if !strings.HasPrefix(frame.Function, targetPackagePrefix) {
+			continue
+		}
+		matching = append(matching, frame)
+		if len(matching) == limit {
+			break
+		}
+	}
+	return matching
+}
+
+// panicClass maps a runtime panic or fatal error message to a short class name
+func panicClass(message string) string {
+	classes := []struct {
+		needle string
+		class  string
+	}{
+		{"index out of range", "index out of range"},
+		{"slice bounds out of range", "slice bounds out of range"},
+		{"nil pointer dereference", "nil dereference"},
+		{"invalid memory address", "nil dereference"},
+		{"integer divide by zero", "division by zero"},
+		{"assignment to entry in nil map", "nil map write"},
+		{"interface conversion", "failed type assertion"},
+		{"close of closed channel", "closed channel"},
+		{"send on closed channel", "closed channel"},
+		{"concurrent map", "concurrent map access"},
+		{"all goroutines are asleep", "deadlock"},
+		{"stack overflow", "stack overflow"},
+		{"out of memory", "out of memory"},
+		{"makeslice: len out of range", "invalid allocation size"},
+	}
+
+	for _, c := range classes {
+		if strings.Contains(message, c.needle) {
+			return c.class
+		}
+	}
+	if message == "" {
+		return "unknown"
+	}
+	return "custom panic"
+}
+
+// sourceExcerpt returns the lines around line in file, with the faulting line marked by ">"
+func sourceExcerpt(file string, line int, context int) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	scanner := bufio.NewScanner(f)
+	for current := 1; scanner.Scan(); current++ {
+		if current < line-context {
+			continue
+		}
+		if current > line+context {
+			break
+		}
+		marker := " "
+		if current == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s %4d | %s\n", marker, current, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("line %d is past the end of %s", line, file)
+	}
+	return b.String(), nil
+}
+
+// crashReport renders the panic class, the target frames and a source excerpt for each of them
+func crashReport(class string, message string, frames []stackFrame) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Panic class: %s\n", class)
+	fmt.Fprintf(&b, "Panic: %s\n", message)
+
+	if len(frames) == 0 {
+		b.WriteString("No frames in the target module\n")
+		return b.String()
+	}
+
+	for i, frame := range frames {
+		fmt.Fprintf(&b, "\n#%d %s\n", i, frame.Function)
+		fmt.Fprintf(&b, "   File: %s\n", frame.File)
+		fmt.Fprintf(&b, "   Line: %d\n", frame.Line)
+		excerpt, err := sourceExcerpt(frame.File, frame.Line, excerptContext)
+		if err != nil {
+			fmt.Fprintf(&b, "   Source unavailable: %v\n", err)
+			continue
+		}
+		b.WriteString(excerpt)
+	}
+	return b.String()
+}
+
+// recoveredCrashReport builds a crash report from inside a deferred recover, where the stack of
+// the panicking goroutine is still intact
+func recoveredCrashReport(r interface{}) (report string, frames []stackFrame) {
+	message := fmt.Sprint(r)
+	frames = targetFrames(parseStackFrames(string(debug.Stack())), 3)
+	return crashReport(panicClass(message), message, frames), frames
+}
+
+// TestStackTraceMapping checks that a real panic is resolved to the faulting source line
+func TestStackTraceMapping(t *testing.T) {
+	if currentExpectation(t) == expectFixed {
+		t.Skip("the fixed tree does not panic")
+	}
+
+	var report string
+	var frames []stackFrame
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				report, frames = recoveredCrashReport(r)
+			}
+		}()
+		colors := [types.MessageLevelCount]uint{}
+		_, _ = discord.CreatePayloadFromItems([]types.MessageItem{}, "", colors, 0)
+	}()
+
+	if len(frames) == 0 {
+		t.Fatalf("❌ No target frames resolved:\n%s", report)
+	}
+	t.Logf("Crash report:\n%s", report)
+
+	top := frames[0]
+	if !strings.HasSuffix(top.Function, "discord.CreatePayloadFromItems") {
+		t.Errorf("❌ Expected the top frame in CreatePayloadFromItems, got %v", top)
+	}
+	if !strings.HasSuffix(top.File, "discord_json.go") {
+		t.Errorf("❌ Expected the top frame in discord_json.go, got %v", top)
+	}
+
+	excerpt, err := sourceExcerpt(top.File, top.Line, 0)
+	if err != nil {
+		t.Fatalf("❌ Could not read faulting line: %v", err)
+	}
+	if !strings.Contains(excerpt, "embeds[0]") {
+		t.Errorf("❌ Faulting line does not index embeds: %s", excerpt)
+	}
+}
+
+// TestPanicClass checks the classification of runtime error messages
+func TestPanicClass(t *testing.T) {
+	testCases := map[string]string{
+		"runtime error: index out of range [0] with length 0":              "index out of range",
+		"runtime error: slice bounds out of range [:-1]":                   "slice bounds out of range",
+		"runtime error: invalid memory address or nil pointer dereference": "nil dereference",
+		"runtime error: integer divide by zero":                            "division by zero",
+		"assignment to entry in nil map":                                   "nil map write",
+		"interface conversion: interface {} is string, not int":            "failed type assertion",
+		"concurrent map writes":                                            "concurrent map access",
+		"all goroutines are asleep - deadlock!":                            "deadlock",
+		"message is empty":                                                 "custom panic",
+		"":                                                                 "unknown",
+	}
+
+	for message, expected := range testCases {
+		if actual := panicClass(message); actual != expected {
+			t.Errorf("❌ panicClass(%q) = %q, expected %q", message, actual, expected)
+		}
+	}
+}
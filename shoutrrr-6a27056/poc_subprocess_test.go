@@ -0,0 +1,295 @@
+package shoutrrr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containrrr/shoutrrr/pkg/router"
+	"github.com/containrrr/shoutrrr/pkg/services/discord"
+	"github.com/containrrr/shoutrrr/pkg/types"
+	"github.com/containrrr/shoutrrr/pkg/util"
+)
+
+// childExploitEnv names the exploit that TestSubprocessExploitChild runs inside the child process
+const childExploitEnv = "SHOUTRRR_POC_CHILD_EXPLOIT"
+
+// childTimeout bounds a single child process, a hanging exploit is reported as a finding
+const childTimeout = 30 * time.Second
+
+// subprocessExploit is an exploit that is run in a separate test process, so that crashes
+// that cannot be recovered in-process still produce a verdict
+type subprocessExploit struct {
+	// Run triggers the exploit. It only returns if the target survives
+	Run func()
+	// CrashesWhenVulnerable is whether the exploit is expected to crash the vulnerable tree
+	CrashesWhenVulnerable bool
+	Description           string
+}
+
+var subprocessExploits = map[string]subprocessExploit{
+	"empty-items-no-title": {
+		Run: func() {
+			colors := [types.MessageLevelCount]uint{0xFF0000, 0x00FF00, 0x0000FF, 0xFFFF00, 0xFF00FF}
+			_, _ = discord.CreatePayloadFromItems([]types.MessageItem{}, "", colors, 0)
+		},
+		CrashesWhenVulnerable: true,
+		Description:           "Same call as exploit_demo.go, panics in the test goroutine",
+	},
+	"empty-items-sender-goroutine": {
+		Run: func() {
+			done := make(chan struct{})
+			go func() {
+				// No recover here, just like a sender goroutine in an application
+				colors := [types.MessageLevelCount]uint{}
+				_, _ = discord.CreatePayloadFromItems(nil, "", colors, 0)
+				close(done)
+			}()
+			<-done
+		},
+		CrashesWhenVulnerable: true,
+		Description:           "Panics in a goroutine where recover() in the caller cannot help",
+	},
+	"partitioned-empty-message": {
+		Run: func() {
+			limits := types.MessageLimit{ChunkSize: 2000, TotalChunkSize: 6000, ChunkCount: 1}
+			items, omitted := util.PartitionMessage("", limits, 100)
+			colors := [types.MessageLevelCount]uint{}
+			_, _ = discord.CreatePayloadFromItems(items, "", colors, omitted)
+		},
+		CrashesWhenVulnerable: true,
+		Description:           "Empty message with no room for content chunks",
+	},
+	"router-send-empty-message": {
+		Run: func() {
+			sender, err := router.New(nil, "discord://abc123def456@123456789")
+			if err != nil {
+				panic(err)
+			}
+			// The panic, if any, happens before a request is made
+			_ = sender.Send("", nil)
+		},
+		CrashesWhenVulnerable: true,
+		Description:           "Empty message through ServiceRouter.Send, the service panics in a sender goroutine",
+	},
+	"router-fanout-one-panics": {
+		Run: func() {
+			sender, err := router.New(nil,
+				"discord://abc123def456@123456789?splitlines=no",
+				"discord://abc123def456@123456789",
+				"discord://abc123def456@123456789?splitlines=no&title=Deploy")
+			if err != nil {
+				panic(err)
+			}
+			_ = sender.Send("", nil)
+		},
+		CrashesWhenVulnerable: true,
+		Description:           "Only the second of three URLs panics on the empty message, the crash takes the other deliveries down",
+	},
+	"normal-message": {
+		Run: func() {
+			items := []types.MessageItem{{Text: "Hello World"}}
+			colors := [types.MessageLevelCount]uint{}
+			_, _ = discord.CreatePayloadFromItems(items, "Test Title", colors, 0)
+		},
+		CrashesWhenVulnerable: false,
+		Description:           "Control case, must never crash",
+	},
+}
+
+// crashFinding is the structured result of running one exploit in a child process
+type crashFinding struct {
+	Exploit  string
+	Crashed  bool
+	TimedOut bool
+	ExitCode int
+	// Kind is "panic", "fatal error" or empty if no crash header was found in stderr
+	Kind         string
+	PanicMessage string
+	// Class is the panic class derived from PanicMessage, see panicClass
+	Class string
+	// Frames are the innermost frames of the crashing goroutine that belong to the target module
+	Frames []stackFrame
+	// Goroutines holds one entry per goroutine in the dump, starting with its "goroutine N [...]:" header
+	Goroutines []string
+	Stderr     string
+	Duration   time.Duration
+}
+
+func (finding crashFinding) String() string {
+	switch {
+	case finding.TimedOut:
+		return fmt.Sprintf("%s: timed out after %v", finding.Exploit, finding.Duration)
+	case finding.Crashed:
+		return fmt.Sprintf("%s: %s, %s (exit code %d, %d goroutines): %s",
+			finding.Exploit, finding.Kind, finding.Class, finding.ExitCode, len(finding.Goroutines), finding.PanicMessage)
+	}
+	return fmt.Sprintf("%s: no crash (exit code %d)", finding.Exploit, finding.ExitCode)
+}
+
+// runExploitInChild re-executes the test binary so that only TestSubprocessExploitChild runs,
+// with the exploit selected through childExploitEnv and a full goroutine dump on crash
+func runExploitInChild(name string) (crashFinding, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), childTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestSubprocessExploitChild$", "-test.v")
+	cmd.Env = append(os.Environ(), childExploitEnv+"="+name, "GOTRACEBACK=all")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	finding := crashFinding{
+		Exploit:  name,
+		Duration: time.Since(start),
+		Stderr:   stderr.String(),
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		finding.TimedOut = true
+		finding.Crashed = true
+		return finding, nil
+	case errors.As(err, &exitErr):
+		finding.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return finding, fmt.Errorf("failed to run child process: %v", err)
+	}
+
+	finding.Kind, finding.PanicMessage = parseCrashHeader(finding.Stderr)
+	finding.Goroutines = parseGoroutineDump(finding.Stderr)
+	if finding.Kind != "" {
+		finding.Class = panicClass(finding.PanicMessage)
+	}
+	if len(finding.Goroutines) > 0 {
+		finding.Frames = targetFrames(parseStackFrames(finding.Goroutines[0]), 3)
+	}
+	finding.Crashed = finding.Kind != "" || (finding.ExitCode != 0 && !strings.Contains(stdout.String(), "--- FAIL"))
+
+	return finding, nil
+}
+
+// parseCrashHeader finds the first "panic: " or "fatal error: " line written by the runtime
+func parseCrashHeader(stderr string) (kind string, message string) {
+	for _, line := range strings.Split(stderr, "\n") {
+		for _, prefix := range []string{"panic: ", "fatal error: "} {
+			if !strings.HasPrefix(line, prefix) {
+				continue
+			}
+			message = strings.TrimPrefix(line, prefix)
+			// The testing package re-panics recovered panics, drop the marker it adds
+			if bracket := strings.LastIndex(message, " [recovered"); bracket > 0 {
+				message = message[:bracket]
+			}
+			return strings.TrimSuffix(prefix, ": "), message
+		}
+	}
+	return "", ""
+}
+
+// parseGoroutineDump splits a traceback into one block per goroutine
+func parseGoroutineDump(stderr string) []string {
+	var goroutines []string
+	var current []string
+
+	flush := func() {
+		if len(current) > 0 {
+			goroutines = append(goroutines, strings.TrimRight(strings.Join(current, "\n"), "\n"))
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(stderr, "\n") {
+		switch {
+		case strings.HasPrefix(line, "goroutine ") && strings.HasSuffix(line, ":"):
+			flush()
+			current = append(current, line)
+		case current != nil && line == "":
+			flush()
+		case current != nil:
+			current = append(current, line)
+		}
+	}
+	flush()
+
+	return goroutines
+}
+
+// TestSubprocessExploitChild is the entry point inside the child process and is skipped otherwise
+func TestSubprocessExploitChild(t *testing.T) {
+	name := os.Getenv(childExploitEnv)
+	if name == "" {
+		t.Skip("only runs as a child of TestSubprocessCrashCapture")
+	}
+
+	exploit, found := subprocessExploits[name]
+	if !found {
+		t.Fatalf("unknown exploit %q", name)
+	}
+	// Exploits that get as far as sending must not reach the real API
+	newWebhookStandIn(t)
+
+	exploit.Run()
+	t.Logf("exploit %q returned normally", name)
+}
+
+// TestSubprocessCrashCapture runs every exploit in its own process and turns the outcome into a
+// crash finding, so that unrecoverable crashes are reported instead of aborting the run
+func TestSubprocessCrashCapture(t *testing.T) {
+	if os.Getenv(childExploitEnv) != "" {
+		t.Skip("already running as a child process")
+	}
+	if testing.Short() {
+		t.Skip("spawns child processes")
+	}
+
+	mode := currentExpectation(t)
+
+	names := make([]string, 0, len(subprocessExploits))
+	for name := range subprocessExploits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		name, exploit := name, subprocessExploits[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			finding, err := runExploitInChild(name)
+			if err != nil {
+				t.Fatalf("❌ %v", err)
+			}
+
+			t.Logf("Description: %s", exploit.Description)
+			t.Logf("Finding: %v", finding)
+			if finding.Crashed && len(finding.Goroutines) > 0 {
+				t.Logf("Crash report:\n%s", crashReport(finding.Class, finding.PanicMessage, finding.Frames))
+				t.Logf("Crashing goroutine:\n%s", finding.Goroutines[0])
+			}
+
+			expectCrash := exploit.CrashesWhenVulnerable && mode == expectVulnerable
+			switch {
+			case finding.Crashed && expectCrash:
+				t.Logf("🚨 VULNERABILITY CONFIRMED in child process!")
+			case finding.Crashed:
+				t.Errorf("❌ Unexpected crash in %s mode:\n%s", mode, finding.Stderr)
+			case expectCrash:
+				t.Errorf("❌ Expected a crash in %s mode but the child exited normally", mode)
+			default:
+				t.Logf("✅ No crash as expected")
+			}
+		})
+	}
+}
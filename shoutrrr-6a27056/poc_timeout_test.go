@@ -0,0 +1,205 @@
+package shoutrrr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowResponse makes the webhook stand-in behave like a stuck or overloaded endpoint
+type slowResponse struct {
+	// Hang accepts the request and never answers until the stand-in is released
+	Hang bool
+	// Delay waits this long before answering
+	Delay time.Duration
+	// DripInterval writes the raw response one byte at a time with this pause in between
+	DripInterval time.Duration
+}
+
+// wait blocks as configured and reports whether the response should still be written
+func (slowdown slowResponse) wait(ctx context.Context, release <-chan struct{}) bool {
+	var timeout <-chan time.Time
+	switch {
+	case slowdown.Hang:
+	case slowdown.Delay > 0:
+		timeout = time.After(slowdown.Delay)
+	default:
+		return true
+	}
+
+	select {
+	case <-timeout:
+		return true
+	case <-release:
+	case <-ctx.Done():
+	}
+	return false
+}
+
+// dripResponse takes over the connection and writes the status line, headers and body one byte
+// per interval, so a client only gets the status once the whole header has trickled in
+func dripResponse(res http.ResponseWriter, status int, body []byte, interval time.Duration, release <-chan struct{}) {
+	hijacker, ok := res.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	defer conn.Close()
+
+	var raw bytes.Buffer
+	fmt.Fprintf(&raw, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	_ = res.Header().Write(&raw)
+	fmt.Fprintf(&raw, "Content-Length: %d\r\nConnection: close\r\n\r\n", len(body))
+	raw.Write(body)
+
+	for _, b := range raw.Bytes() {
+		select {
+		case <-time.After(interval):
+		case <-release:
+			return
+		}
+		if _, err := conn.Write([]byte{b}); err != nil {
+			return
+		}
+	}
+}
+
+// goroutineStacks returns the stack of every goroutine keyed by its id
+func goroutineStacks() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := map[string]string{}
+	for _, block := range strings.Split(string(buf), "\n\n") {
+		// Every block starts with "goroutine 12 [running]:"
+		if fields := strings.Fields(block); len(fields) > 1 && fields[0] == "goroutine" {
+			stacks[fields[1]] = block
+		}
+	}
+	return stacks
+}
+
+// leakedGoroutines waits up to wait for the goroutines started after baseline to exit and
+// returns the stacks of those still running. Connection handlers of the stand-in itself are
+// not counted, their client side shows up as persistConn loops when a connection leaks
+func leakedGoroutines(baseline map[string]string, wait time.Duration) []string {
+	deadline := time.Now().Add(wait)
+	for {
+		http.DefaultClient.CloseIdleConnections()
+
+		var leaked []string
+		for id, stack := range goroutineStacks() {
+			if _, known := baseline[id]; known || strings.Contains(stack, "net/http.(*conn).serve") {
+				continue
+			}
+			leaked = append(leaked, stack)
+		}
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			sort.Strings(leaked)
+			return leaked
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// sendBudget is how long a deploy hook can afford to wait for one notification
+const sendBudget = 2 * time.Second
+
+// TestSlowWebhookEndpoint sends to a webhook that never answers, answers late or drips its
+// response. Send must return within sendBudget and must not leave goroutines behind, otherwise
+// one stuck notification target wedges whatever called it. The discord sender has no timeout,
+// which the empty message fix does not change, so a stuck Send and its goroutines are findings in
+// both modes and only fail for the control case
+func TestSlowWebhookEndpoint(t *testing.T) {
+	standIn := newWebhookStandIn(t)
+	t.Logf("Budget per Send: %v", sendBudget)
+
+	scenarios := []struct {
+		name        string
+		slowdown    slowResponse
+		description string
+	}{
+		{
+			name:        "Immediate response",
+			description: "Control case",
+		},
+		{
+			name:        "Never responds",
+			slowdown:    slowResponse{Hang: true},
+			description: "The endpoint accepts the connection and never writes a response",
+		},
+		{
+			name:        "Responds after 3 seconds",
+			slowdown:    slowResponse{Delay: 3 * time.Second},
+			description: "The endpoint answers, but later than the budget",
+		},
+		{
+			name:        "Drips the response",
+			slowdown:    slowResponse{DripInterval: 100 * time.Millisecond},
+			description: "Every byte of the response arrives 100ms after the previous one",
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario := scenario
+		t.Run(scenario.name, func(t *testing.T) {
+			t.Logf("Description: %s", scenario.description)
+			report, prefix := t.Logf, "🚨"
+			if scenario.slowdown == (slowResponse{}) {
+				report, prefix = t.Errorf, "❌"
+			}
+			standIn.SetSlowdown(scenario.slowdown)
+			defer standIn.SetSlowdown(slowResponse{})
+
+			baseline := goroutineStacks()
+			done := make(chan sendResult, 1)
+			started := time.Now()
+			go func() {
+				done <- sendRecovering(standIn, func() error { return Send(standIn.URL(""), "deploy finished") })
+			}()
+
+			var result sendResult
+			select {
+			case result = <-done:
+				t.Logf("Send returned after %v: %v", time.Since(started).Round(time.Millisecond), result.Err)
+				if result.Panic != nil {
+					t.Errorf("❌ Unexpected panic\n%s", result.CrashReport)
+				}
+			case <-time.After(sendBudget):
+				report("%s Send did not return within %v, the caller is stuck with the endpoint", prefix, sendBudget)
+
+				// Abort the stalled response so the blocked Send can be inspected
+				standIn.Release()
+				select {
+				case result = <-done:
+					t.Logf("After the stand-in closed the connection Send returned after %v: %v",
+						time.Since(started).Round(time.Millisecond), result.Err)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("❌ Send is still blocked after the connection was closed")
+				}
+			}
+
+			if leaked := leakedGoroutines(baseline, time.Second); len(leaked) > 0 {
+				report("%s %d goroutines are left behind:\n%s", prefix, len(leaked), strings.Join(leaked, "\n\n"))
+			} else {
+				t.Logf("✅ No goroutines left behind")
+			}
+		})
+	}
+}
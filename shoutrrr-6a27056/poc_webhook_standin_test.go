@@ -0,0 +1,649 @@
+package shoutrrr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/containrrr/shoutrrr/pkg/services/discord"
+)
+
+// Credentials of the webhook the stand-in accepts, taken from the TestRealWorldExploit scenario
+const (
+	standInWebhookID = "123456789"
+	standInToken     = "abc123def456"
+)
+
+// discordHosts are the hosts the discord service has used in hookURL. Requests to them are
+// routed to the stand-in, so no test ever reaches the real API
+var discordHosts = map[string]bool{
+	"discord.com":    true,
+	"discordapp.com": true,
+}
+
+// JSON error codes of the Discord API that the stand-in returns
+const (
+	discordUnknownWebhook      = 10015
+	discordEmptyMessage        = 50006
+	discordInvalidWebhookToken = 50027
+	discordInvalidFormBody     = 50035
+	discordInvalidJSON         = 50109
+)
+
+// webhookPath matches the execute webhook endpoint, with or without an API version
+var webhookPath = regexp.MustCompile(`^/api(?:/v\d+)?/webhooks/([^/]+)/([^/]+)/?$`)
+
+// discordAPIError is the body of a Discord API error response
+type discordAPIError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+	// Errors maps a field path like "embeds.0.title" to what is wrong with it
+	Errors map[string]string `json:"errors,omitempty"`
+	// RetryAfter is the number of seconds to wait after a 429
+	RetryAfter float64 `json:"retry_after,omitempty"`
+	Global     bool    `json:"global,omitempty"`
+}
+
+func (apiErr *discordAPIError) String() string {
+	if apiErr == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%d %s %v", apiErr.Code, apiErr.Message, apiErr.Errors)
+}
+
+// webhookMessage is the body of an execute webhook request. The discord service only sends
+// a WebhookPayload, content is decoded so that raw JSON messages are validated as well
+type webhookMessage struct {
+	Content string `json:"content,omitempty"`
+	discord.WebhookPayload
+}
+
+// webhookRequest is one request received by the stand-in together with the response it got
+type webhookRequest struct {
+	Method    string
+	Path      string
+	WebhookID string
+	Token     string
+	Body      []byte
+	Message   webhookMessage
+	Status    int
+	Error     *discordAPIError
+	// Header holds the response headers, like Retry-After and X-RateLimit-*
+	Header   http.Header
+	Received time.Time
+}
+
+// webhookStandIn implements the Discord execute webhook endpoint on a local server and records
+// every request. While it is running, http.DefaultTransport routes the Discord hosts to it
+type webhookStandIn struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	webhooks map[string]string
+	requests []webhookRequest
+	// limiter answers with 429 and 5xx responses, nil accepts every request
+	limiter *rateLimiter
+	// slowdown withholds, delays or drips the responses until release is closed
+	slowdown slowResponse
+	release  chan struct{}
+}
+
+// newWebhookStandIn starts a stand-in that accepts standInWebhookID/standInToken and points
+// the default HTTP transport at it until the test ends. The test must not run in parallel, see
+// routeToStandIn
+func newWebhookStandIn(t *testing.T) *webhookStandIn {
+	t.Helper()
+
+	standIn := &webhookStandIn{
+		webhooks: map[string]string{standInWebhookID: standInToken},
+		release:  make(chan struct{}),
+	}
+	standIn.server = httptest.NewServer(standIn)
+	t.Cleanup(standIn.server.Close)
+	// Close waits for running handlers, so stalled responses are released first
+	t.Cleanup(standIn.Release)
+
+	routeToStandIn(t, standIn.server, func(host string) bool {
+		return discordHosts[host]
+	})
+
+	return standIn
+}
+
+// URL returns the shoutrrr URL of the stand-in webhook, query is appended as is
+func (standIn *webhookStandIn) URL(query string) string {
+	serviceURL := fmt.Sprintf("discord://%s@%s", standInToken, standInWebhookID)
+	if query != "" {
+		serviceURL += "?" + query
+	}
+	return serviceURL
+}
+
+// AddWebhook makes the stand-in accept another webhook
+func (standIn *webhookStandIn) AddWebhook(webhookID string, token string) {
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+
+	standIn.webhooks[webhookID] = token
+}
+
+// SetLimits applies limits from now on with fresh buckets
+func (standIn *webhookStandIn) SetLimits(limits rateLimits) {
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+
+	standIn.limiter = newRateLimiter(limits)
+}
+
+// SetSlowdown makes the following responses hang, wait or drip
+func (standIn *webhookStandIn) SetSlowdown(slowdown slowResponse) {
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+
+	standIn.slowdown = slowdown
+}
+
+// Release aborts every stalled response, the connections are closed without an answer
+func (standIn *webhookStandIn) Release() {
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+
+	close(standIn.release)
+	standIn.release = make(chan struct{})
+}
+
+// Requests returns a copy of everything received so far
+func (standIn *webhookStandIn) Requests() []webhookRequest {
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+
+	return append([]webhookRequest(nil), standIn.requests...)
+}
+
+// Reset forgets the recorded requests
+func (standIn *webhookStandIn) Reset() {
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+
+	standIn.requests = nil
+}
+
+func (standIn *webhookStandIn) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	record := webhookRequest{Method: req.Method, Path: req.URL.Path, Header: http.Header{}, Received: time.Now()}
+	record.Status, record.Error = standIn.execute(req, &record)
+
+	standIn.mu.Lock()
+	standIn.requests = append(standIn.requests, record)
+	slowdown, release := standIn.slowdown, standIn.release
+	standIn.mu.Unlock()
+
+	if !slowdown.wait(req.Context(), release) {
+		panic(http.ErrAbortHandler)
+	}
+
+	for key, values := range record.Header {
+		res.Header()[key] = values
+	}
+	var body []byte
+	if record.Error != nil {
+		res.Header().Set("Content-Type", "application/json")
+		body, _ = json.Marshal(record.Error)
+	}
+	if slowdown.DripInterval > 0 {
+		dripResponse(res, record.Status, body, slowdown.DripInterval, release)
+		return
+	}
+	res.WriteHeader(record.Status)
+	_, _ = res.Write(body)
+}
+
+// execute validates the request the way the Discord API does and returns the response status
+func (standIn *webhookStandIn) execute(req *http.Request, record *webhookRequest) (int, *discordAPIError) {
+	match := webhookPath.FindStringSubmatch(req.URL.Path)
+	if match == nil {
+		return http.StatusNotFound, &discordAPIError{Message: "404: Not Found"}
+	}
+	record.WebhookID, record.Token = match[1], match[2]
+
+	if req.Method != http.MethodPost {
+		return http.StatusMethodNotAllowed, &discordAPIError{Message: "405: Method Not Allowed"}
+	}
+
+	standIn.mu.Lock()
+	token, found := standIn.webhooks[record.WebhookID]
+	limiter := standIn.limiter
+	standIn.mu.Unlock()
+	if !found {
+		return http.StatusNotFound, &discordAPIError{Message: "Unknown Webhook", Code: discordUnknownWebhook}
+	}
+	if record.Token != token {
+		return http.StatusUnauthorized, &discordAPIError{Message: "Invalid Webhook Token", Code: discordInvalidWebhookToken}
+	}
+	if status, apiErr := limiter.take(record.WebhookID, record.Header, record.Received); apiErr != nil {
+		return status, apiErr
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return http.StatusBadRequest, &discordAPIError{Message: err.Error()}
+	}
+	record.Body = body
+
+	// Discord reads anything that is not JSON as a form without fields
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/json" {
+		return http.StatusBadRequest, &discordAPIError{Message: "Cannot send an empty message", Code: discordEmptyMessage}
+	}
+	if err := json.Unmarshal(body, &record.Message); err != nil {
+		return http.StatusBadRequest, &discordAPIError{Message: "The request body contains invalid JSON.", Code: discordInvalidJSON}
+	}
+
+	if isEmptyWebhookMessage(record.Message) {
+		return http.StatusBadRequest, &discordAPIError{Message: "Cannot send an empty message", Code: discordEmptyMessage}
+	}
+	if violations := validateWebhookMessage(record.Message); len(violations) > 0 {
+		return http.StatusBadRequest, &discordAPIError{Message: "Invalid Form Body", Code: discordInvalidFormBody, Errors: formErrors(violations)}
+	}
+
+	// Without ?wait=true Discord answers a successful execute with an empty 204
+	return http.StatusNoContent, nil
+}
+
+// isEmptyWebhookMessage reports whether nothing visible would be posted. Discord trims
+// whitespace from text fields, so a message of only whitespace counts as empty
+func isEmptyWebhookMessage(message webhookMessage) bool {
+	if strings.TrimSpace(message.Content) != "" {
+		return false
+	}
+	for _, embed := range message.Embeds {
+		footer := ""
+		if embed.Footer != nil {
+			footer = embed.Footer.Text
+		}
+		if strings.TrimSpace(embed.Title+embed.Content+footer) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// standInEnv holds the URL of the stand-in the running test routes to
+const standInEnv = "SHOUTRRR_POC_STAND_IN"
+
+// routeToStandIn makes http.DefaultTransport send requests for the hosts that routes accepts to
+// server until the test ends. Services that use http.Post or http.DefaultClient follow it, the
+// discord service has no client to inject a transport into. The transport is global, so the test
+// must not run in parallel: t.Setenv panics if it or a parent called t.Parallel, and makes a
+// later t.Parallel call panic
+func routeToStandIn(t *testing.T, server *httptest.Server, routes func(host string) bool) {
+	t.Helper()
+	t.Setenv(standInEnv, server.URL)
+
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("❌ Invalid stand-in URL: %v", err)
+	}
+
+	previous := http.DefaultTransport
+	http.DefaultTransport = standInTransport{target: target, routes: routes, next: previous}
+	t.Cleanup(func() {
+		http.DefaultTransport = previous
+	})
+}
+
+// standInTransport sends requests for the routed hosts to the stand-in and everything else to next
+type standInTransport struct {
+	target *url.URL
+	routes func(host string) bool
+	next   http.RoundTripper
+}
+
+func (transport standInTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !transport.routes(req.URL.Hostname()) {
+		return transport.next.RoundTrip(req)
+	}
+
+	routed := req.Clone(req.Context())
+	routed.URL.Scheme = transport.target.Scheme
+	routed.URL.Host = transport.target.Host
+	routed.Host = ""
+	return transport.next.RoundTrip(routed)
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the wrapped transport
+func (transport standInTransport) CloseIdleConnections() {
+	if closer, ok := transport.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// sendOutcome is the observable result of sending a message through shoutrrr to the stand-in
+type sendOutcome int
+
+const (
+	// sendDelivered means Send returned nil and the stand-in accepted every request
+	sendDelivered sendOutcome = iota
+	// sendRejected means the stand-in answered at least one request with an error status
+	sendRejected
+	// sendRefused means Send returned an error without making a request
+	sendRefused
+	// sendPanic means Send panicked
+	sendPanic
+	// sendInconsistent means the error returned by Send disagrees with what the stand-in answered
+	sendInconsistent
+)
+
+func (outcome sendOutcome) String() string {
+	switch outcome {
+	case sendDelivered:
+		return "delivered"
+	case sendRejected:
+		return "rejected by Discord"
+	case sendRefused:
+		return "refused before sending"
+	case sendPanic:
+		return "panic"
+	case sendInconsistent:
+		return "inconsistent"
+	}
+	return fmt.Sprintf("sendOutcome(%d)", int(outcome))
+}
+
+// sendResult is what a Send call returned together with what the stand-in received meanwhile
+type sendResult struct {
+	Err   error
+	Panic interface{}
+	// CrashReport is built while the stack of the panic is intact, see recoveredCrashReport
+	CrashReport string
+	Requests    []webhookRequest
+}
+
+func (result sendResult) Outcome() sendOutcome {
+	if result.Panic != nil {
+		return sendPanic
+	}
+
+	rejected := 0
+	for _, req := range result.Requests {
+		if req.Error != nil {
+			rejected++
+		}
+	}
+
+	switch {
+	case result.Err == nil && rejected == 0 && len(result.Requests) > 0:
+		return sendDelivered
+	case result.Err != nil && rejected > 0:
+		return sendRejected
+	case result.Err != nil && len(result.Requests) == 0:
+		return sendRefused
+	}
+	return sendInconsistent
+}
+
+// sendRecovering calls send against a freshly reset stand-in and turns a panic into a result
+func sendRecovering(standIn *webhookStandIn, send func() error) (result sendResult) {
+	standIn.Reset()
+	defer func() {
+		if result.Panic = recover(); result.Panic != nil {
+			result.CrashReport, _ = recoveredCrashReport(result.Panic)
+		}
+		result.Requests = standIn.Requests()
+	}()
+
+	result.Err = send()
+	return result
+}
+
+// repeatLines returns count lines of length runes each
+func repeatLines(count int, length int) string {
+	lines := make([]string, count)
+	for i := range lines {
+		lines[i] = strings.Repeat("x", length)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// TestWebhookStandIn checks that the stand-in answers like the Discord API before it is used
+// to judge what the discord service sends
+func TestWebhookStandIn(t *testing.T) {
+	standIn := newWebhookStandIn(t)
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		ctype  string
+		body   string
+		status int
+		code   int
+	}{
+		{"Valid embed", "POST", "/api/webhooks/123456789/abc123def456", "application/json", `{"embeds":[{"description":"hi"}]}`, 204, 0},
+		{"Versioned path", "POST", "/api/v10/webhooks/123456789/abc123def456", "application/json", `{"content":"hi"}`, 204, 0},
+		{"Unknown webhook", "POST", "/api/webhooks/987654321/abc123def456", "application/json", `{"content":"hi"}`, 404, discordUnknownWebhook},
+		{"Wrong token", "POST", "/api/webhooks/123456789/wrong", "application/json", `{"content":"hi"}`, 401, discordInvalidWebhookToken},
+		{"Wrong path", "POST", "/api/webhooks/123456789", "application/json", `{"content":"hi"}`, 404, 0},
+		{"Wrong method", "GET", "/api/webhooks/123456789/abc123def456", "", "", 405, 0},
+		{"Not JSON", "POST", "/api/webhooks/123456789/abc123def456", "text/plain", `{"content":"hi"}`, 400, discordEmptyMessage},
+		{"Invalid JSON", "POST", "/api/webhooks/123456789/abc123def456", "application/json", `{"embeds":[`, 400, discordInvalidJSON},
+		{"No embeds", "POST", "/api/webhooks/123456789/abc123def456", "application/json", `{"embeds":[]}`, 400, discordEmptyMessage},
+		{"Whitespace embed", "POST", "/api/webhooks/123456789/abc123def456", "application/json", `{"embeds":[{"description":" \n"}]}`, 400, discordEmptyMessage},
+		{"Eleven embeds", "POST", "/api/webhooks/123456789/abc123def456", "application/json", `{"embeds":[` + strings.Repeat(`{"title":"x"},`, 10) + `{"title":"x"}]}`, 400, discordInvalidFormBody},
+		{"Too many characters", "POST", "/api/webhooks/123456789/abc123def456", "application/json", `{"embeds":[{"description":"` + strings.Repeat("x", 4000) + `"},{"description":"` + strings.Repeat("x", 2001) + `"}]}`, 400, discordInvalidFormBody},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			standIn.Reset()
+
+			// The request goes to discord.com to check the routing of the transport as well
+			req, err := http.NewRequest(tc.method, "https://discord.com"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.ctype != "" {
+				req.Header.Set("Content-Type", tc.ctype)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("❌ Request failed: %v", err)
+			}
+			defer res.Body.Close()
+
+			var apiErr discordAPIError
+			if res.StatusCode != http.StatusNoContent {
+				if err := json.NewDecoder(res.Body).Decode(&apiErr); err != nil {
+					t.Errorf("❌ Error response is not JSON: %v", err)
+				}
+			}
+
+			if res.StatusCode != tc.status || apiErr.Code != tc.code {
+				t.Errorf("❌ Expected %d with code %d, got %d with %+v", tc.status, tc.code, res.StatusCode, apiErr)
+			}
+			if requests := standIn.Requests(); len(requests) != 1 {
+				t.Errorf("❌ Expected the stand-in to record 1 request, got %d", len(requests))
+			}
+		})
+	}
+}
+
+// TestDiscordSendEndToEnd sends messages with shoutrrr.Send through the discord service to the
+// stand-in, so the whole path from the URL to the HTTP request is exercised. A panic on
+// the ServiceRouter path happens in a sender goroutine, see "router-send-empty-message" in
+// TestSubprocessCrashCapture
+func TestDiscordSendEndToEnd(t *testing.T) {
+	standIn := newWebhookStandIn(t)
+	mode := currentExpectation(t)
+	t.Logf("Expectation mode: %s", mode)
+
+	testCases := []struct {
+		name       string
+		query      string
+		message    string
+		vulnerable sendOutcome
+		fixed      sendOutcome
+		// delivered is the text that must arrive in the embeds when the message is delivered
+		delivered   string
+		description string
+	}{
+		{
+			name:        "Short message",
+			message:     "deploy finished",
+			vulnerable:  sendDelivered,
+			fixed:       sendDelivered,
+			delivered:   "deploy finished",
+			description: "Control case",
+		},
+		{
+			name:        "Empty message",
+			message:     "",
+			vulnerable:  sendPanic,
+			fixed:       sendRefused,
+			description: "splitLines defaults to yes and drops the empty line, CreatePayloadFromItems gets no items",
+		},
+		{
+			name:        "Only newlines",
+			message:     "\n\n\n",
+			vulnerable:  sendPanic,
+			fixed:       sendRefused,
+			description: "Every line is empty, same path as the empty message",
+		},
+		{
+			name:        "Single space",
+			message:     " ",
+			vulnerable:  sendRejected,
+			fixed:       sendRejected,
+			description: "The whitespace item is sent and Discord refuses the empty embed",
+		},
+		{
+			name:        "Whitespace lines",
+			message:     " \n\t\n ",
+			vulnerable:  sendRejected,
+			fixed:       sendRejected,
+			description: "Whitespace lines are not empty for MessageItemsFromLines",
+		},
+		{
+			name:        "Empty message with title",
+			query:       "title=Alert",
+			message:     "",
+			vulnerable:  sendDelivered,
+			fixed:       sendRefused,
+			delivered:   "Alert",
+			description: "The title embed hides the missing content, an empty notification is posted",
+		},
+		{
+			name:        "Empty message without splitLines",
+			query:       "splitlines=no",
+			message:     "",
+			vulnerable:  sendRejected,
+			fixed:       sendRefused,
+			description: "PartitionMessage returns one empty item, the empty embed is sent to Discord",
+		},
+		{
+			name:        "Single space without splitLines",
+			query:       "splitlines=no",
+			message:     " ",
+			vulnerable:  sendRejected,
+			fixed:       sendRejected,
+			description: "The whitespace chunk is sent and Discord refuses the empty embed",
+		},
+		{
+			name:        "Oversized lines",
+			message:     repeatLines(10, 1000),
+			vulnerable:  sendRejected,
+			fixed:       sendRejected,
+			description: "6000 characters of lines plus the omitted footer exceed the embed total, the empty message fix does not change that",
+		},
+		{
+			name:        "Oversized message without splitLines",
+			query:       "splitlines=no",
+			message:     strings.Repeat("word ", 1600),
+			vulnerable:  sendRejected,
+			fixed:       sendRejected,
+			description: "The partitioned chunks plus the omitted footer exceed the embed total, the empty message fix does not change that",
+		},
+		{
+			name:        "Lines ending near the total limit",
+			message:     repeatLines(2, 2000) + "\n" + strings.Repeat("x", 1998) + "\n" + strings.Repeat("x", 10),
+			vulnerable:  sendPanic,
+			fixed:       sendPanic,
+			description: "Two runes are left for the last line, trimming it for the ellipsis slices out of range in MessageItemsFromLines, which the empty message fix does not touch",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := tc.vulnerable
+			if mode == expectFixed {
+				expected = tc.fixed
+			}
+
+			result := sendRecovering(standIn, func() error {
+				return Send(standIn.URL(tc.query), tc.message)
+			})
+			outcome := result.Outcome()
+
+			t.Logf("Description: %s", tc.description)
+			t.Logf("Message: %d runes, %d requests, error: %v", utf8.RuneCountInString(tc.message), len(result.Requests), result.Err)
+			for i, req := range result.Requests {
+				t.Logf("  Request[%d]: %d %s, %d embeds, API error: %v", i, req.Status, req.Path, len(req.Message.Embeds), req.Error)
+			}
+
+			if outcome == sendPanic {
+				if expected == sendPanic {
+					t.Logf("🚨 VULNERABILITY CONFIRMED through shoutrrr.Send!\n%s", result.CrashReport)
+				} else {
+					t.Errorf("❌ Unexpected panic in %s mode:\n%s", mode, result.CrashReport)
+				}
+				return
+			}
+
+			if outcome != expected {
+				t.Errorf("❌ Expected %v in %s mode, got %v", expected, mode, outcome)
+			}
+			if outcome == sendRejected {
+				t.Logf("🚨 Notification dropped: Discord refused what the discord service sent")
+			}
+
+			for _, req := range result.Requests {
+				if req.WebhookID != standInWebhookID || req.Token != standInToken {
+					t.Errorf("❌ Request went to webhook %q with token %q", req.WebhookID, req.Token)
+				}
+			}
+
+			if outcome == sendDelivered && !requestsContain(result.Requests, tc.delivered) {
+				t.Errorf("❌ Expected %q to arrive in the embeds", shorten(tc.delivered, 40))
+			}
+		})
+	}
+}
+
+// requestsContain reports whether text is the title or content of any received embed
+func requestsContain(requests []webhookRequest, text string) bool {
+	for _, req := range requests {
+		for _, embed := range req.Message.Embeds {
+			if embed.Title == text || strings.Contains(embed.Content, text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shorten limits text to max runes for log output
+func shorten(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "..."
+}